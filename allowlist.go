@@ -0,0 +1,60 @@
+package swiffy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPAllowlistHandler wraps h so that only clients with IP in cidrs reach it, others get 403.
+// An entry of cidrs is either a CIDR range like 10.1.0.0/16 or a single IP. It panics on an
+// invalid entry.
+//
+// Client IP is taken from RemoteAddr. When trustForwardedFor is set, e.g. behind a load
+// balancer, it's taken from the last X-Forwarded-For entry instead, the address the proxy in
+// front saw; entries before it are set by client and can't be trusted. Don't set it when the
+// handler is reachable other than through such a proxy, clients could then claim any IP.
+func IPAllowlistHandler(h http.Handler, cidrs []string, trustForwardedFor bool) http.Handler {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				panic(fmt.Sprintf("invalid IP %q in allowlist", c))
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(fmt.Sprintf("invalid CIDR %q in allowlist, %v", c, err))
+		}
+		nets = append(nets, n)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r, trustForwardedFor)
+		for _, n := range nets {
+			if ip != nil && n.Contains(ip) {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		http.Error(w, http.StatusText(403), 403)
+	})
+}
+
+// clientIP returns IP of client of r, nil if it can't be told.
+func clientIP(r *http.Request, trustForwardedFor bool) net.IP {
+	if trustForwardedFor {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			parts := strings.Split(xff[len(xff)-1], ",")
+			return net.ParseIP(strings.TrimSpace(parts[len(parts)-1]))
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,52 @@
+package swiffy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPAllowlistHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	cidrs := []string{"10.1.0.0/16", "192.168.1.7", "2001:db8::/32"}
+	for _, c := range []struct {
+		remoteAddr, xff string
+		trustXFF        bool
+		want            int
+	}{
+		{"10.1.2.3:1234", "", false, 200},
+		{"10.2.2.3:1234", "", false, 403},
+		{"192.168.1.7:1234", "", false, 200},
+		{"192.168.1.8:1234", "", false, 403},
+		{"[2001:db8::1]:1234", "", false, 200},
+		{"[2001:db9::1]:1234", "", false, 403},
+		{"garbage", "", false, 403},
+		// X-Forwarded-For is ignored unless trusted.
+		{"172.16.0.1:1234", "10.1.2.3", false, 403},
+		{"10.1.2.3:1234", "172.16.0.1", false, 200},
+		// Trusted, the last entry counts, earlier ones are up to client.
+		{"172.16.0.1:1234", "10.1.2.3", true, 200},
+		{"172.16.0.1:1234", "10.1.2.3, 172.16.0.9", true, 403},
+		{"172.16.0.1:1234", "172.16.0.9, 10.1.2.3", true, 200},
+		{"10.1.2.3:1234", "172.16.0.9", true, 403},
+		{"10.1.2.3:1234", "", true, 200},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remoteAddr
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+		w := httptest.NewRecorder()
+		IPAllowlistHandler(ok, cidrs, c.trustXFF).ServeHTTP(w, r)
+		if w.Code != c.want {
+			t.Errorf("%s with X-Forwarded-For %q, trusted %v, got %d, want %d", c.remoteAddr, c.xff, c.trustXFF, w.Code, c.want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("invalid entry accepted")
+		}
+	}()
+	IPAllowlistHandler(ok, []string{"10.1.0.0/33"}, false)
+}
@@ -0,0 +1,23 @@
+package swiffy
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/jsonpb"
+)
+
+type anyResolverKey struct{}
+
+// WithAnyResolver returns a copy of ctx carrying resolver, which the default RequestDecoder
+// consults, instead of the global proto registry, for types of google.protobuf.Any in json
+// requests. It allows types unknown at startup, e.g. from plugins. Requests are decoded before
+// swiffy Middleware runs, so set it on the HTTP request in an http.Handler wrapping swiffy's,
+// or for all requests with Options.BaseContext.
+func WithAnyResolver(ctx context.Context, resolver jsonpb.AnyResolver) context.Context {
+	return context.WithValue(ctx, anyResolverKey{}, resolver)
+}
+
+func anyResolver(ctx context.Context) jsonpb.AnyResolver {
+	r, _ := ctx.Value(anyResolverKey{}).(jsonpb.AnyResolver)
+	return r
+}
@@ -0,0 +1,67 @@
+package swiffy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"yuheng.io/swiffy/internal/testpb"
+)
+
+// pluginResolver resolves type.example.com/plugin.Msg, unknown to the proto registry, to Msg.
+type pluginResolver struct{}
+
+func (pluginResolver) Resolve(typeURL string) (proto.Message, error) {
+	if typeURL == "type.example.com/plugin.Msg" {
+		return &testpb.Msg{}, nil
+	}
+	return nil, fmt.Errorf("unknown type %s", typeURL)
+}
+
+type anyService struct{}
+
+// Detail responds name in detail of req.
+func (anyService) Detail(ctx context.Context, req *testpb.Msg) (*testpb.Msg, error) {
+	if req.Detail == nil {
+		return &testpb.Msg{}, nil
+	}
+	m := &testpb.Msg{}
+	if err := proto.Unmarshal(req.Detail.Value, m); err != nil {
+		return nil, err
+	}
+	return &testpb.Msg{Name: m.Name}, nil
+}
+
+func TestWithAnyResolver(t *testing.T) {
+	req := `{"detail":{"@type":"type.example.com/plugin.Msg","name":"n"}}`
+	withResolver := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r.WithContext(WithAnyResolver(r.Context(), pluginResolver{})))
+		})
+	}
+	for _, c := range []struct {
+		name string
+		h    http.Handler
+		body string
+		want string
+	}{
+		{"http middleware", withResolver(NewServiceHandler(anyService{}, nil)), req, `{"name":"n"}`},
+		{"BaseContext", NewServiceHandler(anyService{}, &Options{BaseContext: WithAnyResolver(context.Background(), pluginResolver{})}), req, `{"name":"n"}`},
+		{"bulk", withResolver(NewServiceHandler(anyService{}, &Options{AllowBulk: true})), "[" + req + "]", `[{"status":200,"result":{"name":"n"}}]` + "\n"},
+	} {
+		w := serve(c.h, "POST", "/?method=Detail", c.body)
+		if w.Code != 200 || w.Body.String() != c.want {
+			t.Errorf("%s got %d %q, want %q", c.name, w.Code, w.Body.String(), c.want)
+		}
+	}
+
+	// The registry alone doesn't know the type.
+	w := serve(NewServiceHandler(anyService{}, nil), "POST", "/?method=Detail", req)
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "plugin.Msg") {
+		t.Errorf("without resolver got %d %q, want 400", w.Code, w.Body.String())
+	}
+}
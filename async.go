@@ -0,0 +1,26 @@
+package swiffy
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+)
+
+// callAsync calls backend with req in a new goroutine, detached from request r.
+func (h *methodHandler) callAsync(ctx context.Context, r *http.Request, req interface{}) {
+	ctx = context.WithoutCancel(ctx)
+	// Keep what's needed for logging, r may be reused once the handler returns.
+	lr := &http.Request{Method: r.Method, URL: r.URL}
+	lr = lr.WithContext(ctx)
+	go func() {
+		defer func() {
+			// There's no HTTP server to recover panics here.
+			if p := recover(); p != nil {
+				h.opt.logRequestf(lr, "panic in async method: %v\n%s", p, debug.Stack())
+			}
+		}()
+		if _, err := h.call(ctx, req); err != nil {
+			h.opt.logRequestf(lr, "async method failed, %v", err)
+		}
+	}()
+}
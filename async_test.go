@@ -0,0 +1,73 @@
+package swiffy
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"yuheng.io/swiffy/internal/testpb"
+)
+
+type asyncKey struct{}
+
+// asyncService blocks Work until release is closed, then reports ctx.Err() and a value of ctx.
+type asyncService struct {
+	release chan struct{}
+	done    chan string
+}
+
+func (s asyncService) Work(ctx context.Context, req *testpb.Msg) (*testpb.Msg, error) {
+	<-s.release
+	v, _ := ctx.Value(asyncKey{}).(string)
+	if err := ctx.Err(); err != nil {
+		v = err.Error()
+	}
+	s.done <- req.Name + " " + v
+	return req, nil
+}
+
+func TestAsyncMethods(t *testing.T) {
+	s := asyncService{release: make(chan struct{}), done: make(chan string, 1)}
+	h := NewServiceHandler(s, &Options{AsyncMethods: map[string]bool{"Work": true}, AllowBulk: true})
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), asyncKey{}, "kept"))
+	r := httptest.NewRequest("POST", "/?method=Work", strings.NewReader(`{"name":"a"}`)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		h.ServeHTTP(w, r)
+		close(served)
+	}()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request waited for async method")
+	}
+	if w.Code != 202 || w.Body.Len() != 0 {
+		t.Errorf("got %d %q, want 202 with empty body", w.Code, w.Body.String())
+	}
+	// The request is over, the method carries on.
+	cancel()
+	close(s.release)
+	select {
+	case got := <-s.done:
+		if got != "a kept" {
+			t.Errorf("method saw %q, want context with value and not cancelled", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("async method not run")
+	}
+
+	// Bulk can't be fire-and-forget per element.
+	w = serve(h, "POST", "/?method=Work", `[{"name":"a"},{"name":"b"}]`)
+	if w.Code != 400 {
+		t.Errorf("bulk got %d %q, want 400", w.Code, w.Body.String())
+	}
+	select {
+	case got := <-s.done:
+		t.Errorf("bulk ran method: %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
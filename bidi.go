@@ -0,0 +1,134 @@
+package swiffy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Bidirectional streaming methods receive requests and send responses, any number of each, in
+// the same HTTP request, like gRPC bidi streaming methods:
+//
+//	func(ctx context.Context, recv func() (*requestProto, error), send func(*responseProto) error) error
+//
+// Request body is newline delimited JSON read as recv is called, responses are written as
+// newline delimited JSON like streaming methods do, see stream.go. HTTP/1.1 full duplex is
+// enabled so responses can go out while body is still being read, client must read them
+// concurrently with sending. recv returns io.EOF once client is done sending, the method can
+// keep sending after that. A line that can't be decoded makes recv fail with an error of
+// status 400 telling the line number, reported like other errors if the method returns it.
+// recv fails once ctx is done or the method has returned.
+
+// isBidiShape tells whether fnt is of a bidirectional streaming method.
+func isBidiShape(fnt reflect.Type) bool {
+	if fnt.NumIn() != 3 || fnt.NumOut() != 1 || fnt.Out(0) != errType || !fnt.In(0).Implements(ctxType) {
+		return false
+	}
+	recv, send := fnt.In(1), fnt.In(2)
+	return recv.Kind() == reflect.Func && recv.NumIn() == 0 && recv.NumOut() == 2 &&
+		recv.Out(0).Kind() == reflect.Ptr && recv.Out(1) == errType &&
+		send.Kind() == reflect.Func && send.NumIn() == 1 && send.NumOut() == 1 &&
+		send.In(0).Kind() == reflect.Ptr && send.Out(0) == errType
+}
+
+// requestStream reads requests of a bidirectional streaming method from lines of body.
+type requestStream struct {
+	ctx context.Context
+	h   *methodHandler
+	sc  *bufio.Scanner
+
+	mu   sync.Mutex
+	line int
+	err  error
+}
+
+// recvFunc makes recv function of type t returning requests.
+func (s *requestStream) recvFunc(t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func([]reflect.Value) []reflect.Value {
+		req, err := s.recv()
+		if err != nil {
+			return []reflect.Value{reflect.Zero(t.Out(0)), reflect.ValueOf(&err).Elem()}
+		}
+		return []reflect.Value{reflect.ValueOf(req), reflect.Zero(errType)}
+	})
+}
+
+func (s *requestStream) recv() (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.err == nil {
+		if err := s.ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !s.sc.Scan() {
+			switch err := s.sc.Err(); {
+			case err == nil:
+				s.err = io.EOF
+			case err == bufio.ErrTooLong:
+				s.err = &ingestError{413, s.line + 1, fmt.Errorf("Request too large")}
+			case s.ctx.Err() != nil:
+				return nil, s.ctx.Err()
+			default:
+				s.err = &ingestError{400, s.line + 1, fmt.Errorf("Read request from HTTP body failed, %v", err)}
+			}
+			break
+		}
+		s.line++
+		b := bytes.TrimSpace(s.sc.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		req := reflect.New(s.h.reqType).Interface()
+		if err := s.h.decode(s.ctx, req, b, "json"); err != nil {
+			s.err = &ingestError{400, s.line, fmt.Errorf("Decode request failed, %v", err)}
+			break
+		}
+		if err := s.h.transform(req); err != nil {
+			s.err = &ingestError{400, s.line, err}
+			break
+		}
+		return req, nil
+	}
+	return nil, s.err
+}
+
+// serveBidi serves a bidirectional streaming method. rb is request passed by the request form
+// value, body is read otherwise.
+func (h *methodHandler) serveBidi(w http.ResponseWriter, r *http.Request, obs *Observation, rb []byte, format string) {
+	if format != "json" {
+		h.opt.httpError(w, r, 400, fmt.Sprintf("Method %s streams newline delimited JSON, not %s", h.name, format))
+		return
+	}
+	var body io.Reader = bytes.NewReader(rb)
+	if rb == nil {
+		if err := h.opt.checkContentType(r, "ndjson"); err != nil {
+			h.opt.httpError(w, r, 415, err.Error())
+			return
+		}
+		rd, closeBody, err := openBody(r)
+		if err != nil {
+			h.opt.httpError(w, r, err.(WithHTTPStatus).HTTPStatus(), err.Error())
+			return
+		}
+		defer closeBody()
+		body = rd
+		// Without it HTTP/1.1 server stops reading body once response is written.
+		http.NewResponseController(w).EnableFullDuplex()
+	}
+	cr := &countingReader{ReadCloser: io.NopCloser(body)}
+	defer func() { obs.RequestBytes = atomic.LoadInt64(&cr.n) }()
+	s := &requestStream{ctx: r.Context(), h: h, sc: h.lineScanner(cr)}
+	recvType := reflect.FuncOf(nil, []reflect.Type{reflect.PtrTo(h.reqType), errType}, false)
+	defer func() {
+		s.mu.Lock()
+		s.err = errStreamClosed
+		s.mu.Unlock()
+	}()
+	h.serveStream(w, r, s.recvFunc(recvType).Interface(), format)
+}
@@ -0,0 +1,178 @@
+package swiffy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"yuheng.io/swiffy/internal/testpb"
+)
+
+// chatService echoes requests of Chat, then sends one with count of requests once client is
+// done sending. Its recv error and send func are passed to errs and sends when set.
+type chatService struct {
+	errs  chan error
+	sends chan func(*testpb.Msg) error
+}
+
+func (s chatService) Chat(ctx context.Context, recv func() (*testpb.Msg, error), send func(*testpb.Msg) error) error {
+	var n int64
+	for {
+		req, err := recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if s.errs != nil {
+				s.errs <- err
+			}
+			return err
+		}
+		n++
+		if err := send(&testpb.Msg{Name: req.Name}); err != nil {
+			return err
+		}
+	}
+	if s.sends != nil {
+		s.sends <- send
+	}
+	return send(&testpb.Msg{Name: "done", Count: n})
+}
+
+// startChat starts a Chat call to srv, returning writer of request body, and reader of
+// response lines once response is received.
+func startChat(ctx context.Context, t *testing.T, srv *httptest.Server) (*io.PipeWriter, *http.Response, *bufio.Reader) {
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, "POST", srv.URL+"/?method=Chat", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	type result struct {
+		res *http.Response
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := srv.Client().Do(req)
+		done <- result{res, err}
+	}()
+	// Response headers go out with the first response, so one request is sent first.
+	if _, err := io.WriteString(pw, `{"name":"a"}`+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.res.StatusCode != 200 {
+		t.Fatalf("got %d, want 200", r.res.StatusCode)
+	}
+	return pw, r.res, bufio.NewReader(r.res.Body)
+}
+
+func readLine(t *testing.T, br *bufio.Reader) string {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read response failed, %v", err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+func TestBidi(t *testing.T) {
+	srv := httptest.NewServer(NewServiceHandler(chatService{}, nil))
+	defer srv.Close()
+	pw, res, br := startChat(context.Background(), t, srv)
+	defer res.Body.Close()
+
+	// Each response is read before the next request is sent.
+	if got := readLine(t, br); got != `{"name":"a"}` {
+		t.Errorf("first response %s", got)
+	}
+	io.WriteString(pw, `{"name":"b"}`+"\n\n")
+	if got := readLine(t, br); got != `{"name":"b"}` {
+		t.Errorf("second response %s", got)
+	}
+
+	// Half-close, method keeps sending after recv gets io.EOF.
+	pw.Close()
+	if got := readLine(t, br); got != `{"name":"done","count":"2"}` {
+		t.Errorf("response after EOF %s", got)
+	}
+	if _, err := br.ReadString('\n'); err != io.EOF {
+		t.Errorf("stream not ended, %v", err)
+	}
+	if e := res.Trailer.Get("X-Stream-Error"); e != "" {
+		t.Errorf("got X-Stream-Error %q", e)
+	}
+}
+
+func TestBidiDecodeError(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := httptest.NewServer(NewServiceHandler(chatService{errs: errs}, nil))
+	defer srv.Close()
+	pw, res, br := startChat(context.Background(), t, srv)
+	defer res.Body.Close()
+	readLine(t, br)
+	io.WriteString(pw, "\n"+`{"name":"b"}`+"\n"+`{"name":`+"\n")
+	pw.Close()
+	if got := readLine(t, br); got != `{"name":"b"}` {
+		t.Errorf("second response %s", got)
+	}
+	if _, err := io.ReadAll(br); err != nil {
+		t.Fatal(err)
+	}
+	// Blank lines count.
+	want := "Line 4: Decode request failed"
+	if e := res.Trailer.Get("X-Stream-Error"); !strings.HasPrefix(e, want) {
+		t.Errorf("got X-Stream-Error %q, want %q", e, want)
+	}
+	var ie *ingestError
+	if err := <-errs; !errors.As(err, &ie) || ie.HTTPStatus() != 400 || ie.line != 4 {
+		t.Errorf("recv got %v, want 400 of line 4", err)
+	}
+}
+
+func TestBidiSendAfterReturn(t *testing.T) {
+	sends := make(chan func(*testpb.Msg) error, 1)
+	srv := httptest.NewServer(NewServiceHandler(chatService{sends: sends}, nil))
+	defer srv.Close()
+	pw, res, br := startChat(context.Background(), t, srv)
+	defer res.Body.Close()
+	readLine(t, br)
+	pw.Close()
+	if _, err := io.ReadAll(br); err != nil {
+		t.Fatal(err)
+	}
+	send := <-sends
+	if err := send(&testpb.Msg{Name: "late"}); err != errStreamClosed {
+		t.Errorf("send after return got %v, want %v", err, errStreamClosed)
+	}
+}
+
+func TestBidiClientGone(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := httptest.NewServer(NewServiceHandler(chatService{errs: errs}, nil))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	pw, res, br := startChat(ctx, t, srv)
+	defer pw.Close()
+	readLine(t, br)
+	// Method is blocked in recv when client goes away.
+	cancel()
+	res.Body.Close()
+	select {
+	case err := <-errs:
+		if err == nil || err == io.EOF {
+			t.Errorf("recv got %v after client is gone", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("recv not cancelled when client is gone")
+	}
+}
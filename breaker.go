@@ -0,0 +1,165 @@
+package swiffy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker provides a Middleware that fails fast with 503 for methods whose recent calls
+// mostly failed, instead of piling up requests on a failing downstream. State is kept per
+// method, keyed by MethodName(ctx).
+//
+// A method's breaker trips open when, within Window, at least MinRequests calls were made and
+// the ratio of failed ones reaches FailureRatio. After Cooldown, a single probe call is let
+// through (half-open), its success closes the breaker and its failure opens it again.
+//
+// Zero fields take defaults: FailureRatio 0.5, MinRequests 10, Window 10s, Cooldown 5s.
+type CircuitBreaker struct {
+	FailureRatio float64
+	MinRequests  int
+	Window       time.Duration
+	Cooldown     time.Duration
+	// IsFailure tells whether an error returned by handler counts as failure, by default errors
+	// with 5xx status (errors without status are 500) count, client errors and cancellation
+	// don't.
+	IsFailure func(err error) bool
+
+	mu     sync.Mutex
+	states map[string]*breakerState
+}
+
+type breakerMode int
+
+const (
+	breakerClosed breakerMode = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type breakerState struct {
+	mode        breakerMode
+	windowStart time.Time
+	total       int
+	failed      int
+	openUntil   time.Time
+}
+
+// Middleware implements Middleware.
+func (b *CircuitBreaker) Middleware(h Handler) Handler {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		method := MethodName(ctx)
+		probe, ok := b.allow(method)
+		if !ok {
+			return nil, Error(503, "Circuit breaker open", nil)
+		}
+		// A panicking handler counts as failure, so a probe never leaves breaker half-open.
+		failed := true
+		defer func() { b.record(method, probe, failed) }()
+		res, err := h(ctx, req)
+		failed = err != nil && b.isFailure(ctx, err)
+		return res, err
+	}
+}
+
+func (b *CircuitBreaker) isFailure(ctx context.Context, err error) bool {
+	if b.IsFailure != nil {
+		return b.IsFailure(err)
+	}
+	if ctx.Err() == context.Canceled {
+		return false
+	}
+	if e, ok := err.(WithHTTPStatus); ok {
+		return e.HTTPStatus() >= 500
+	}
+	return true
+}
+
+func (b *CircuitBreaker) state(method string, now time.Time) *breakerState {
+	if b.states == nil {
+		b.states = map[string]*breakerState{}
+	}
+	s, ok := b.states[method]
+	if !ok {
+		s = &breakerState{windowStart: now}
+		b.states[method] = s
+	}
+	return s
+}
+
+// allow tells whether a call can go through, and whether it's the half-open probe.
+func (b *CircuitBreaker) allow(method string) (probe bool, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	s := b.state(method, now)
+	switch s.mode {
+	case breakerOpen:
+		if now.Before(s.openUntil) {
+			return false, false
+		}
+		s.mode = breakerHalfOpen
+		return true, true
+	case breakerHalfOpen:
+		// Probe in flight.
+		return false, false
+	default:
+		if now.Sub(s.windowStart) >= b.window() {
+			s.windowStart, s.total, s.failed = now, 0, 0
+		}
+		return false, true
+	}
+}
+
+func (b *CircuitBreaker) record(method string, probe bool, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	s := b.state(method, now)
+	if probe {
+		if failed {
+			s.mode, s.openUntil = breakerOpen, now.Add(b.cooldown())
+		} else {
+			s.mode, s.windowStart, s.total, s.failed = breakerClosed, now, 0, 0
+		}
+		return
+	}
+	if s.mode != breakerClosed {
+		return
+	}
+	s.total++
+	if failed {
+		s.failed++
+	}
+	if s.total >= b.minRequests() && float64(s.failed)/float64(s.total) >= b.failureRatio() {
+		s.mode, s.openUntil = breakerOpen, now.Add(b.cooldown())
+	}
+}
+
+func (b *CircuitBreaker) failureRatio() float64 {
+	if b.FailureRatio <= 0 {
+		return 0.5
+	}
+	return b.FailureRatio
+}
+
+func (b *CircuitBreaker) minRequests() int {
+	if b.MinRequests <= 0 {
+		return 10
+	}
+	return b.MinRequests
+}
+
+func (b *CircuitBreaker) window() time.Duration {
+	if b.Window <= 0 {
+		return 10 * time.Second
+	}
+	return b.Window
+}
+
+func (b *CircuitBreaker) cooldown() time.Duration {
+	if b.Cooldown <= 0 {
+		return 5 * time.Second
+	}
+	return b.Cooldown
+}
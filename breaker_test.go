@@ -0,0 +1,59 @@
+package swiffy
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"yuheng.io/swiffy/internal/testpb"
+)
+
+// switchService fails Call while failing is nonzero.
+type switchService struct {
+	failing *int32
+	calls   *int32
+}
+
+func (s switchService) Call(ctx context.Context, req *testpb.Msg) (*testpb.Msg, error) {
+	atomic.AddInt32(s.calls, 1)
+	if atomic.LoadInt32(s.failing) != 0 {
+		return nil, errors.New("downstream failed")
+	}
+	return req, nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var failing, calls int32
+	b := &CircuitBreaker{MinRequests: 4, Cooldown: 20 * time.Millisecond}
+	h := NewServiceHandler(switchService{failing: &failing, calls: &calls}, &Options{Middleware: b.Middleware})
+	expect := func(step string, status int, called bool) {
+		t.Helper()
+		before := atomic.LoadInt32(&calls)
+		w := serve(h, "POST", "/?method=Call", `{}`)
+		if got := atomic.LoadInt32(&calls) != before; w.Code != status || got != called {
+			t.Errorf("%s got %d, backend called %v, want %d, called %v", step, w.Code, got, status, called)
+		}
+	}
+
+	// Half of the calls failing trips it.
+	expect("success", 200, true)
+	expect("success", 200, true)
+	atomic.StoreInt32(&failing, 1)
+	expect("failure", 500, true)
+	expect("failure trips", 500, true)
+	expect("open", 503, false)
+
+	// A failed probe opens it again.
+	time.Sleep(30 * time.Millisecond)
+	expect("failed probe", 500, true)
+	expect("reopened", 503, false)
+
+	// A successful probe closes it.
+	time.Sleep(30 * time.Millisecond)
+	atomic.StoreInt32(&failing, 0)
+	expect("probe", 200, true)
+	expect("closed", 200, true)
+
+}
@@ -0,0 +1,63 @@
+package swiffy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+)
+
+// responseBuffer is an in-memory http.ResponseWriter, used when a response needs to be
+// inspected or transformed before it's sent to client.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	// limit caps size of body when positive.
+	limit int
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: http.Header{}}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = 200
+	}
+	if b.limit > 0 && b.body.Len()+len(p) > b.limit {
+		return 0, errResponseTooLarge{b.limit}
+	}
+	return b.body.Write(p)
+}
+
+type errResponseTooLarge struct {
+	limit int
+}
+
+func (e errResponseTooLarge) Error() string {
+	return fmt.Sprintf("response exceeds %d bytes", e.limit)
+}
+
+// flush sends buffered response to w.
+func (b *responseBuffer) flush(w http.ResponseWriter) error {
+	h := w.Header()
+	for k, v := range b.header {
+		h[k] = v
+	}
+	if b.status == 0 {
+		b.status = 200
+	}
+	w.WriteHeader(b.status)
+	_, err := w.Write(b.body.Bytes())
+	return err
+}
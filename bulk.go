@@ -0,0 +1,135 @@
+package swiffy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// bulkResult is the outcome of one element of a bulk request. The response of a bulk request
+// is a JSON array of bulkResult in the same order as request elements, e.g.
+//
+//	[{"status":200,"result":{...}},{"status":404,"error":"Not found"}]
+//
+// error is the encoded WithMessage message when available, otherwise a string of error text.
+type bulkResult struct {
+	Status int             `json:"status"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+// isJSONArray tells whether src looks like a top level JSON array.
+func isJSONArray(src []byte) bool {
+	src = bytes.TrimLeft(src, " \t\r\n")
+	return len(src) > 0 && src[0] == '['
+}
+
+// serveBulk calls backend once for each element of a JSON array and aggregates the results,
+// failures are reported per element, the HTTP status is 200 as long as the array is decoded.
+func (h *methodHandler) serveBulk(w http.ResponseWriter, r *http.Request, src []byte) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(src, &items); err != nil {
+		h.opt.httpError(w, r, 400, fmt.Sprintf("Decode bulk request failed, %v", err))
+		return
+	}
+	results := make([]*bulkResult, len(items))
+	for i, item := range items {
+		results[i] = h.callBulkItem(w, r, item)
+	}
+	if h.opt.DeprecationWarnings {
+		dedupHeader(w.Header(), "Warning")
+	}
+	// Timings of all elements add up.
+	setServerTiming(w, r.Context())
+	// Options.MaxResponseBytes caps the whole array, not each element.
+	buf := newResponseBuffer()
+	buf.limit = h.opt.MaxResponseBytes
+	if err := json.NewEncoder(buf).Encode(results); err != nil {
+		h.opt.logRequestf(r, "%v", err)
+		h.opt.httpError(w, r, 500, fmt.Sprintf("Encode response failed, %v", err))
+		return
+	}
+	if h.opt.Envelope != nil {
+		// The array as a whole is data, per element errors are in it.
+		rb, err := h.opt.Envelope.wrap(r, "data", buf.body.Bytes())
+		if err != nil {
+			h.opt.httpError(w, r, 500, fmt.Sprintf("Encode response failed, %v", err))
+			return
+		}
+		buf.body.Reset()
+		buf.body.Write(rb)
+	}
+	w.Header().Set("Content-Type", contentType(h.opt.ContentTypes, "json"))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	buf.flush(w)
+}
+
+// callBulkItem calls backend with one element of a bulk request. Like a single call, its
+// deprecated fields add Warning headers to w, and StatusField sets its status.
+func (h *methodHandler) callBulkItem(w http.ResponseWriter, r *http.Request, item []byte) *bulkResult {
+	req := reflect.New(h.reqType).Interface()
+	if err := h.decode(r.Context(), req, item, "json"); err != nil {
+		return bulkError(400, fmt.Sprintf("Decode request failed, %v", err))
+	}
+	if err := h.transform(req); err != nil {
+		return bulkError(400, err.Error())
+	}
+	if h.opt.DeprecationWarnings {
+		addDeprecationWarnings(w, req)
+	}
+	res, err := h.call(r.Context(), req)
+	if err != nil {
+		st, text := h.errorStatus(r, err)
+		if e, ok := err.(WithMessage); ok {
+			if m := e.Message(); m != nil {
+				buf := newResponseBuffer()
+				if h.encoder(buf, st, m, "json") == nil {
+					return &bulkResult{Status: st, Error: buf.body.Bytes()}
+				}
+			}
+		}
+		return bulkError(st, text)
+	}
+	status := 200
+	if h.opt.StatusField != "" {
+		if st := statusFromField(res, h.opt.StatusField); st != 0 {
+			if st < 200 || st > 599 {
+				h.opt.logRequestf(r, "Invalid status %d in response field %s", st, h.opt.StatusField)
+				return bulkError(500, http.StatusText(500))
+			}
+			status = st
+		}
+	}
+	if status == 204 || status == 304 {
+		return &bulkResult{Status: status}
+	}
+	buf := newResponseBuffer()
+	buf.limit = h.opt.MaxResponseBytes
+	if err := h.encoder(buf, status, res, "json"); err != nil {
+		return bulkError(500, fmt.Sprintf("Encode response failed, %v", err))
+	}
+	return &bulkResult{Status: status, Result: buf.body.Bytes()}
+}
+
+// dedupHeader drops repeated values of header name, keeping the first of each.
+func dedupHeader(header http.Header, name string) {
+	values := header.Values(name)
+	seen := map[string]bool{}
+	kept := values[:0]
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) > 0 {
+		header[http.CanonicalHeaderKey(name)] = kept
+	}
+}
+
+func bulkError(status int, text string) *bulkResult {
+	b, _ := json.Marshal(text)
+	return &bulkResult{Status: status, Error: b}
+}
@@ -0,0 +1,41 @@
+package swiffy
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// toUTF8 transcodes src to UTF-8 according to charset parameter of contentType, unsupported
+// charsets yield an error with status 415. A missing or malformed Content-Type is taken as UTF-8.
+func toUTF8(contentType string, src []byte) ([]byte, error) {
+	if contentType == "" {
+		return src, nil
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return src, nil
+	}
+	switch cs := strings.ToLower(params["charset"]); cs {
+	case "", "utf-8", "utf8", "us-ascii":
+		return src, nil
+	case "iso-8859-1", "iso8859-1", "latin1", "latin-1":
+		return latin1ToUTF8(src), nil
+	default:
+		return nil, Error(415, fmt.Sprintf("Unsupported charset %s", cs), nil)
+	}
+}
+
+// latin1ToUTF8 transcodes ISO-8859-1, whose bytes map to the first 256 code points of Unicode.
+func latin1ToUTF8(src []byte) []byte {
+	dst := make([]byte, 0, len(src))
+	for _, c := range src {
+		if c < utf8.RuneSelf {
+			dst = append(dst, c)
+		} else {
+			dst = utf8.AppendRune(dst, rune(c))
+		}
+	}
+	return dst
+}
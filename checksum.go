@@ -0,0 +1,56 @@
+package swiffy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"net/http"
+)
+
+// ChecksumAlgorithm is algorithm of X-Content-Checksum header, see Options.ResponseChecksum.
+type ChecksumAlgorithm int
+
+const (
+	// NoChecksum sets no checksum header.
+	NoChecksum ChecksumAlgorithm = iota
+	// ChecksumCRC32 is IEEE CRC-32, cheap, catches accidental corruption.
+	ChecksumCRC32
+	// ChecksumSHA256 is SHA-256, also catches tampering by anyone who can't change the header.
+	ChecksumSHA256
+)
+
+// ContentChecksumHeader carries checksum of response body, like sha256=<hex>.
+const ContentChecksumHeader = "X-Content-Checksum"
+
+func (a ChecksumAlgorithm) String() string {
+	switch a {
+	case ChecksumCRC32:
+		return "crc32"
+	case ChecksumSHA256:
+		return "sha256"
+	default:
+		return ""
+	}
+}
+
+func (a ChecksumAlgorithm) newHash() hash.Hash {
+	switch a {
+	case ChecksumCRC32:
+		return crc32.NewIEEE()
+	case ChecksumSHA256:
+		return sha256.New()
+	default:
+		return nil
+	}
+}
+
+// setChecksum sets X-Content-Checksum header of w to checksum of body in algorithm a.
+func setChecksum(w http.ResponseWriter, a ChecksumAlgorithm, body []byte) {
+	h := a.newHash()
+	if h == nil {
+		return
+	}
+	h.Write(body)
+	w.Header().Set(ContentChecksumHeader, a.String()+"="+hex.EncodeToString(h.Sum(nil)))
+}
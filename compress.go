@@ -0,0 +1,147 @@
+package swiffy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Compression configures compression of responses, negotiated by Accept-Encoding of request.
+// br is preferred over gzip when client accepts both.
+type Compression struct {
+	// MinBytes leaves responses smaller than that uncompressed, as compressing them gains little.
+	MinBytes int
+	// GzipLevel is level of gzip, 0 means gzip.DefaultCompression.
+	GzipLevel int
+	// BrotliQuality is quality of brotli from 1 to 11, 0 means brotli.DefaultCompression. Higher
+	// quality gets smaller output at significant CPU cost, 4 to 6 suits dynamic responses.
+	BrotliQuality int
+	// DisableBrotli sticks to gzip.
+	DisableBrotli bool
+}
+
+// negotiate picks content coding for response to r, or empty for none. A coding listed
+// explicitly, like gzip;q=0, takes precedence over *.
+func (c *Compression) negotiate(r *http.Request) string {
+	qs := map[string]float64{}
+	star := 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, q := parseAcceptCoding(part)
+		switch coding {
+		case "*":
+			star = q
+		case "x-gzip":
+			coding = "gzip"
+			fallthrough
+		default:
+			if _, ok := qs[coding]; !ok {
+				qs[coding] = q
+			}
+		}
+	}
+	accepts := func(coding string) bool {
+		if q, ok := qs[coding]; ok {
+			return q > 0
+		}
+		return star > 0
+	}
+	switch {
+	case !c.DisableBrotli && accepts("br"):
+		return "br"
+	case accepts("gzip"):
+		return "gzip"
+	}
+	return ""
+}
+
+// parseAcceptCoding parses an element of Accept-Encoding like "gzip;q=0.5".
+func parseAcceptCoding(s string) (coding string, q float64) {
+	q = 1
+	params := strings.Split(s, ";")
+	coding = strings.ToLower(strings.TrimSpace(params[0]))
+	for _, p := range params[1:] {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], "q") {
+			if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+				q = v
+			}
+		}
+	}
+	return coding, q
+}
+
+// compressWriter buffers response body, so that it's compressed by finish only when large enough.
+type compressWriter struct {
+	http.ResponseWriter
+	c        *Compression
+	coding   string
+	status   int
+	body     bytes.Buffer
+	finished bool
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = 200
+	}
+	return w.body.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes buffered response to the underlying ResponseWriter, compressed if worthwhile.
+func (w *compressWriter) finish() error {
+	if w.status == 0 {
+		return nil
+	}
+	h := w.Header()
+	if w.body.Len() < w.c.MinBytes || w.status == 204 || w.status == 304 || h.Get("Content-Encoding") != "" {
+		w.ResponseWriter.WriteHeader(w.status)
+		_, err := w.ResponseWriter.Write(w.body.Bytes())
+		return err
+	}
+	var zb bytes.Buffer
+	var zw io.WriteCloser
+	if w.coding == "br" {
+		q := w.c.BrotliQuality
+		if q <= 0 {
+			q = brotli.DefaultCompression
+		}
+		zw = brotli.NewWriterLevel(&zb, q)
+	} else {
+		level := w.c.GzipLevel
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gw, err := gzip.NewWriterLevel(&zb, level)
+		if err != nil {
+			return err
+		}
+		zw = gw
+	}
+	if _, err := zw.Write(w.body.Bytes()); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	h.Set("Content-Encoding", w.coding)
+	h.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(zb.Bytes())
+	return err
+}
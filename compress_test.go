@@ -0,0 +1,66 @@
+package swiffy
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+
+	"yuheng.io/swiffy/internal/testpb"
+)
+
+func TestCompressionNegotiate(t *testing.T) {
+	for _, c := range []struct {
+		accept string
+		c      Compression
+		want   string
+	}{
+		{"", Compression{}, ""},
+		{"gzip", Compression{}, "gzip"},
+		{"x-gzip", Compression{}, "gzip"},
+		{"gzip, br", Compression{}, "br"},
+		{"gzip, br", Compression{DisableBrotli: true}, "gzip"},
+		{"br;q=0, gzip", Compression{}, "gzip"},
+		{"*", Compression{}, "br"},
+		{"*", Compression{DisableBrotli: true}, "gzip"},
+		{"*;q=0", Compression{}, ""},
+		{"gzip;q=0, *", Compression{DisableBrotli: true}, ""},
+		{"*, gzip;q=0", Compression{DisableBrotli: true}, ""},
+		{"br;q=0, *", Compression{}, "gzip"},
+		{"identity", Compression{}, ""},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", c.accept)
+		if got := c.c.negotiate(r); got != c.want {
+			t.Errorf("%q with %+v got %q, want %q", c.accept, c.c, got, c.want)
+		}
+	}
+}
+
+// BenchmarkCompression reports size of a JSON response in each coding as B/resp.
+func BenchmarkCompression(b *testing.B) {
+	req := &testpb.Msg{}
+	for i := 0; i < 200; i++ {
+		req.Items = append(req.Items, &testpb.Msg{Name: fmt.Sprintf("item-%d", i), Count: int64(i), Tags: []string{"a", "b"}})
+	}
+	body, err := (&jsonpb.Marshaler{}).MarshalToString(req)
+	if err != nil {
+		b.Fatal(err)
+	}
+	h := NewServiceHandler(echoService{}, &Options{Compression: &Compression{}})
+	for _, coding := range []string{"identity", "gzip", "br"} {
+		b.Run(coding, func(b *testing.B) {
+			var n int
+			for i := 0; i < b.N; i++ {
+				w := serve(h, "POST", "/?method=Echo", body, "Accept-Encoding", coding)
+				if w.Code != 200 || coding != "identity" && w.Header().Get("Content-Encoding") != coding {
+					b.Fatalf("got %d %s", w.Code, strings.Join(w.Header().Values("Content-Encoding"), ","))
+				}
+				n = w.Body.Len()
+			}
+			b.ReportMetric(float64(n), "B/resp")
+		})
+	}
+}
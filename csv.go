@@ -0,0 +1,61 @@
+package swiffy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// marshalCSV renders rows of msg, elements of its repeated message field, as CSV with a header
+// line of field names of row message. Cells are jsonpb renderings of fields, unquoted for JSON
+// strings, so enums are names, timestamps RFC 3339 and nested messages JSON.
+func (c *protoCodec) marshalCSV(msg proto.Message) ([]byte, error) {
+	m := proto.MessageReflect(msg)
+	fd, err := repeatedMessageField(m.Descriptor(), c.csvField)
+	if err != nil {
+		return nil, fmt.Errorf("Response can't be csv, %v", err)
+	}
+	cols := fd.Message().Fields()
+	var b bytes.Buffer
+	cw := csv.NewWriter(&b)
+	header := make([]string, cols.Len())
+	for i := range header {
+		header[i] = string(cols.Get(i).Name())
+	}
+	cw.Write(header)
+	jm := jsonpb.Marshaler{EnumsAsInts: c.enumsAsInts, EmitDefaults: true, OrigName: true}
+	list := m.Get(fd).List()
+	for i := 0; i < list.Len(); i++ {
+		s, err := jm.MarshalToString(proto.MessageV1(list.Get(i).Message().Interface()))
+		if err != nil {
+			return nil, err
+		}
+		var row map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(s), &row); err != nil {
+			return nil, err
+		}
+		record := make([]string, len(header))
+		for j, name := range header {
+			record[j] = csvCell(row[name])
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return b.Bytes(), cw.Error()
+}
+
+// csvCell turns JSON v into a CSV cell, null is empty.
+func csvCell(v json.RawMessage) string {
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		return s
+	}
+	if string(v) == "null" {
+		return ""
+	}
+	return string(v)
+}
@@ -0,0 +1,55 @@
+package swiffy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// addDeprecationWarnings adds a Warning header to w for each field set in req that's marked
+// deprecated in its proto definition.
+func addDeprecationWarnings(w http.ResponseWriter, req interface{}) {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return
+	}
+	seen := map[protoreflect.FullName]bool{}
+	collectDeprecated(proto.MessageReflect(m), func(fd protoreflect.FieldDescriptor) {
+		if seen[fd.FullName()] {
+			return
+		}
+		seen[fd.FullName()] = true
+		// 299 is miscellaneous persistent warning, - stands for unknown agent, see RFC 7234.
+		w.Header().Add("Warning", fmt.Sprintf(`299 - "Deprecated field %s"`, fd.FullName()))
+	})
+}
+
+// collectDeprecated calls fn with each deprecated field set in m, recursing into messages.
+func collectDeprecated(m protoreflect.Message, fn func(protoreflect.FieldDescriptor)) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if opts, ok := fd.Options().(*descriptorpb.FieldOptions); ok && opts.GetDeprecated() {
+			fn(fd)
+		}
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() != nil {
+				v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+					collectDeprecated(mv.Message(), fn)
+					return true
+				})
+			}
+		case fd.IsList():
+			if fd.Message() != nil {
+				for i, l := 0, v.List(); i < l.Len(); i++ {
+					collectDeprecated(l.Get(i).Message(), fn)
+				}
+			}
+		case fd.Message() != nil:
+			collectDeprecated(v.Message(), fn)
+		}
+		return true
+	})
+}
@@ -0,0 +1,48 @@
+package swiffy
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Envelope wraps json responses as {"data": <result>, "meta": {...}}, and errors as
+// {"error": <encoded message or error text>, "meta": {...}}. meta is omitted when empty.
+// Other formats are not affected. meta includes "request_id" when the request has one, see
+// RequestIDHandler. A bulk response, see Options.AllowBulk, is wrapped as a whole, its array
+// being data.
+type Envelope struct {
+	// ServerTime adds "server_time" to meta, in RFC 3339 format with nanoseconds, e.g.
+	// 2006-01-02T15:04:05.999999999Z07:00, trailing zeros of fraction removed.
+	ServerTime bool
+	// Meta returns extra meta fields for a request, it can be nil.
+	Meta func(r *http.Request) map[string]interface{}
+}
+
+func (e *Envelope) wrap(r *http.Request, key string, payload json.RawMessage) ([]byte, error) {
+	env := map[string]interface{}{key: payload}
+	meta := map[string]interface{}{}
+	if e.Meta != nil {
+		for k, v := range e.Meta(r) {
+			meta[k] = v
+		}
+	}
+	if id := RequestID(r.Context()); id != "" {
+		meta["request_id"] = id
+	}
+	if e.ServerTime {
+		meta["server_time"] = time.Now().Format(time.RFC3339Nano)
+	}
+	if len(meta) > 0 {
+		env["meta"] = meta
+	}
+	return json.Marshal(env)
+}
+
+// envelopeKey tells under which key a response of status goes.
+func envelopeKey(status int) string {
+	if status >= 400 {
+		return "error"
+	}
+	return "data"
+}
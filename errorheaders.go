@@ -0,0 +1,50 @@
+package swiffy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrorHeadersMode tells whether error responses report the error in headers X-Error-Code,
+// the HTTP status, and X-Error-Message, the error text, for clients that read errors from
+// headers rather than bodies.
+type ErrorHeadersMode int
+
+const (
+	// ErrorHeadersOff reports errors in body only.
+	ErrorHeadersOff ErrorHeadersMode = iota
+	// ErrorHeadersAndBody reports errors in both headers and body.
+	ErrorHeadersAndBody
+	// ErrorHeadersOnly reports errors in headers, with an empty body.
+	ErrorHeadersOnly
+)
+
+// maxErrorHeaderLen caps length of X-Error-Message.
+const maxErrorHeaderLen = 1024
+
+// setErrorHeaders sets error headers of status and text as ErrorHeaders asks, and tells whether
+// body should be left out.
+func (opt *Options) setErrorHeaders(w http.ResponseWriter, status int, text string) (headersOnly bool) {
+	if opt.ErrorHeaders == ErrorHeadersOff {
+		return false
+	}
+	w.Header().Set("X-Error-Code", strconv.Itoa(status))
+	w.Header().Set("X-Error-Message", sanitizeHeaderValue(text))
+	return opt.ErrorHeaders == ErrorHeadersOnly
+}
+
+// sanitizeHeaderValue makes s safe as a header value: control characters, CR and LF among
+// them, become spaces, so that s can't inject headers, and it's cut to maxErrorHeaderLen.
+func sanitizeHeaderValue(s string) string {
+	s = strings.TrimSpace(strings.Map(func(c rune) rune {
+		if c < 0x20 || c == 0x7f {
+			return ' '
+		}
+		return c
+	}, s))
+	if len(s) > maxErrorHeaderLen {
+		s = strings.ToValidUTF8(s[:maxErrorHeaderLen], "")
+	}
+	return s
+}
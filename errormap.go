@@ -0,0 +1,45 @@
+package swiffy
+
+import (
+	"errors"
+)
+
+// ErrorStatus returns an ErrorMapper that gives errors matching any of targets, by errors.Is,
+// HTTP status, keeping their text. It's for errors that aren't ours to make implement
+// WithHTTPStatus, e.g. sentinel errors of a shared validator to be reported as 422:
+//
+//	ErrorStatus(422, validate.ErrInvalid)
+func ErrorStatus(status int, targets ...error) ErrorMapper {
+	return ErrorStatusFunc(status, func(err error) bool {
+		for _, t := range targets {
+			if errors.Is(err, t) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// ErrorStatusFunc is like ErrorStatus, for errors for which match returns true, e.g. errors
+// of a type, as told by errors.As.
+func ErrorStatusFunc(status int, match func(err error) bool) ErrorMapper {
+	return func(err error) error {
+		if !match(err) {
+			return err
+		}
+		return Error(status, err.Error(), nil)
+	}
+}
+
+// ChainErrorMappers combines mappers into one ErrorMapper, applying them in order, each to the
+// error as translated by the ones before.
+func ChainErrorMappers(mappers ...ErrorMapper) ErrorMapper {
+	return func(err error) error {
+		for _, m := range mappers {
+			if mapped := m(err); mapped != nil {
+				err = mapped
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,127 @@
+package swiffy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"yuheng.io/swiffy/internal/testpb"
+)
+
+var (
+	errInvalid  = errors.New("invalid")
+	errNotFound = errors.New("no such thing")
+)
+
+type quotaError struct{}
+
+func (quotaError) Error() string { return "quota exceeded" }
+
+// validatorService fails Validate with the error named by req.Name.
+type validatorService struct{}
+
+func (validatorService) Validate(ctx context.Context, req *testpb.Msg) (*testpb.Msg, error) {
+	switch req.Name {
+	case "invalid":
+		return nil, errInvalid
+	case "wrapped":
+		return nil, fmt.Errorf("field name: %w", errInvalid)
+	case "notfound":
+		return nil, errNotFound
+	case "quota":
+		return nil, fmt.Errorf("check: %w", quotaError{})
+	case "other":
+		return nil, errors.New("other")
+	}
+	return req, nil
+}
+
+func TestErrorStatus(t *testing.T) {
+	isQuota := func(err error) bool {
+		var q quotaError
+		return errors.As(err, &q)
+	}
+	mapper := ChainErrorMappers(
+		ErrorStatus(422, errInvalid),
+		ErrorStatus(404, errNotFound),
+		ErrorStatusFunc(429, isQuota),
+		// Sees the error translated by the ones before.
+		func(err error) error {
+			if e, ok := err.(WithHTTPStatus); ok && e.HTTPStatus() == 404 {
+				return Error(410, "Gone", nil)
+			}
+			return err
+		},
+	)
+	h := NewServiceHandler(validatorService{}, &Options{ErrorMapper: mapper})
+	for _, c := range []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"ok", 200, `{"name":"ok"}`},
+		{"invalid", 422, "invalid\n"},
+		{"wrapped", 422, "field name: invalid\n"},
+		{"notfound", 410, "Gone\n"},
+		{"quota", 429, "check: quota exceeded\n"},
+		{"other", 500, "other\n"},
+	} {
+		w := serve(h, "POST", "/?method=Validate", `{"name":"`+c.name+`"}`)
+		if w.Code != c.status || w.Body.String() != c.body {
+			t.Errorf("%s got %d %q, want %d %q", c.name, w.Code, w.Body.String(), c.status, c.body)
+		}
+	}
+}
+
+// fakeStatus mimics status.Status of grpc.
+type fakeStatus struct {
+	code uint32
+	msg  string
+}
+
+func (s *fakeStatus) Code() uint32    { return s.code }
+func (s *fakeStatus) Message() string { return s.msg }
+
+type fakeStatusError struct{ s *fakeStatus }
+
+func (e fakeStatusError) Error() string           { return "rpc error: " + e.s.msg }
+func (e fakeStatusError) GRPCStatus() *fakeStatus { return e.s }
+
+// grpcService fails Call with a NotFound status, wrapped as req.Name tells.
+type grpcService struct{}
+
+func (grpcService) Call(ctx context.Context, req *testpb.Msg) (*testpb.Msg, error) {
+	err := fakeStatusError{&fakeStatus{code: 5, msg: "no such book"}}
+	switch req.Name {
+	case "plain":
+		return nil, err
+	case "wrapped":
+		return nil, fmt.Errorf("get book: %w", err)
+	case "twice":
+		return nil, fmt.Errorf("handler: %w", fmt.Errorf("get book: %w", err))
+	case "joined":
+		return nil, errors.Join(errors.New("cleanup failed"), err)
+	}
+	return req, nil
+}
+
+func TestGRPCErrorMapper(t *testing.T) {
+	h := NewServiceHandler(grpcService{}, &Options{ErrorMapper: GRPCErrorMapper})
+	for _, c := range []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"ok", 200, `{"name":"ok"}`},
+		{"plain", 404, "no such book\n"},
+		{"wrapped", 404, "no such book\n"},
+		{"twice", 404, "no such book\n"},
+		{"joined", 404, "no such book\n"},
+	} {
+		w := serve(h, "POST", "/?method=Call", `{"name":"`+c.name+`"}`)
+		if w.Code != c.status || w.Body.String() != c.body {
+			t.Errorf("%s got %d %q, want %d %q", c.name, w.Code, w.Body.String(), c.status, c.body)
+		}
+	}
+}
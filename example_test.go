@@ -0,0 +1,34 @@
+package swiffy_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"yuheng.io/swiffy"
+	"yuheng.io/swiffy/internal/testpb"
+)
+
+type greeter struct{}
+
+func (greeter) Hello(ctx context.Context, req *testpb.Msg) (*testpb.Msg, error) {
+	return &testpb.Msg{Name: "hello " + req.Name}, nil
+}
+
+// Client code under test talks to the handler in-process through a standard http.Client.
+func Example_handlerTransport() {
+	client := &http.Client{Transport: &swiffy.HandlerTransport{Handler: swiffy.NewServiceHandler(greeter{}, nil)}}
+	resp, err := client.Post("http://test/api?method=Hello", "application/json", strings.NewReader(`{"name":"world"}`))
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Println(resp.StatusCode, resp.Header.Get("Content-Type"))
+	fmt.Println(string(body))
+	// Output:
+	// 200 text/json; charset=utf-8
+	// {"name":"hello world"}
+}
@@ -1,12 +1,22 @@
 module yuheng.io/swiffy/examples
 
+go 1.21
+
 replace yuheng.io/swiffy => ../
 
 require (
-	github.com/golang/protobuf v1.2.0
+	github.com/golang/protobuf v1.5.4
+	google.golang.org/grpc v1.16.0
+	yuheng.io/swiffy v0.0.0-20181127072811-f4e211d7107a
+)
+
+require (
+	github.com/andybalholm/brotli v1.1.0 // indirect
 	golang.org/x/net v0.0.0-20181114220301-adae6a3d119a // indirect
+	golang.org/x/sync v0.10.0 // indirect
 	golang.org/x/sys v0.0.0-20181122145206-62eef0e2fa9b // indirect
+	golang.org/x/text v0.3.0 // indirect
 	google.golang.org/genproto v0.0.0-20181109154231-b5d43981345b // indirect
-	google.golang.org/grpc v1.16.0
-	yuheng.io/swiffy v0.0.0-20181127072811-f4e211d7107a
+	google.golang.org/protobuf v1.33.0 // indirect
+	sigs.k8s.io/yaml v1.4.0 // indirect
 )
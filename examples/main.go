@@ -14,20 +14,17 @@ import (
 
 // protoLogger is an example of swiffy.Middleware usage: intercept and print request and responese,
 // you can do more like modify request before calling underliring handler etc.
-func protoLogger(h swiffy.Handler) swiffy.Handler {
-	return func(ctx context.Context, req interface{}) (interface{}, error) {
-		if reqProto, ok := req.(proto.Message); ok {
-			log.Printf("Request: %s", proto.MarshalTextString(reqProto))
-		}
-		res, err := h(ctx, req)
-		if err != nil {
-			log.Printf("Response: error %v", err)
-		} else if resProto, ok := res.(proto.Message); ok {
-			log.Printf("Response: %s", proto.MarshalTextString(resProto))
-		}
-		return res, err
+// swiffy.ProtoMiddleware hands over request as proto.Message, non-proto requests skip it.
+var protoLogger = swiffy.ProtoMiddleware(func(ctx context.Context, req proto.Message, next swiffy.Handler) (interface{}, error) {
+	log.Printf("Request: %s", proto.MarshalTextString(req))
+	res, err := next(ctx, req)
+	if err != nil {
+		log.Printf("Response: error %v", err)
+	} else if resProto, ok := res.(proto.Message); ok {
+		log.Printf("Response: %s", proto.MarshalTextString(resProto))
 	}
-}
+	return res, err
+}, false)
 
 // helloServ implements gRPC Hello service
 type helloServ struct{}
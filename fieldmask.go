@@ -0,0 +1,117 @@
+package swiffy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const fieldMaskName = "google.protobuf.FieldMask"
+
+// validateFieldMask checks update semantics of req carrying a google.protobuf.FieldMask field:
+// every path must name a field, and only fields covered by the mask may be set. Paths are
+// relative to the resource being updated, i.e. the only other message field of req if there's
+// exactly one, like book in {book, update_mask}, or req itself otherwise. An empty mask puts no
+// limit on fields set. Requests without mask pass.
+func validateFieldMask(req interface{}) error {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return nil
+	}
+	rm := proto.MessageReflect(m)
+	fields := rm.Descriptor().Fields()
+	var maskField protoreflect.FieldDescriptor
+	var others []protoreflect.FieldDescriptor
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			continue
+		}
+		if fd.Message().FullName() == fieldMaskName && maskField == nil {
+			maskField = fd
+		} else {
+			others = append(others, fd)
+		}
+	}
+	if maskField == nil || !rm.Has(maskField) {
+		return nil
+	}
+	paths := rm.Get(maskField).Message().Get(maskField.Message().Fields().ByName("paths")).List()
+	if paths.Len() == 0 {
+		return nil
+	}
+	resource := rm
+	if len(others) == 1 {
+		resource = rm.Get(others[0]).Message()
+	}
+	tree := maskTree{}
+	for i := 0; i < paths.Len(); i++ {
+		path := paths.Get(i).String()
+		if err := checkMaskPath(resource.Descriptor(), path); err != nil {
+			return err
+		}
+		tree.add(strings.Split(path, "."))
+	}
+	if resource == rm {
+		// The mask itself is set of course.
+		tree[string(maskField.Name())] = nil
+	}
+	return tree.checkSet(resource, "")
+}
+
+// checkMaskPath checks path names a field of md, segments before the last one must be singular
+// message fields.
+func checkMaskPath(md protoreflect.MessageDescriptor, path string) error {
+	segs := strings.Split(path, ".")
+	for i, seg := range segs {
+		fd := md.Fields().ByName(protoreflect.Name(seg))
+		if fd == nil {
+			return fmt.Errorf("Invalid update mask path %q, %s has no field %s", path, md.FullName(), seg)
+		}
+		if i < len(segs)-1 {
+			if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+				return fmt.Errorf("Invalid update mask path %q, %s is not a message", path, seg)
+			}
+			md = fd.Message()
+		}
+	}
+	return nil
+}
+
+// maskTree is paths of a mask by segment, a nil subtree covers the whole field.
+type maskTree map[string]maskTree
+
+func (t maskTree) add(segs []string) {
+	sub, ok := t[segs[0]]
+	if ok && sub == nil {
+		return
+	}
+	if len(segs) == 1 {
+		t[segs[0]] = nil
+		return
+	}
+	if sub == nil {
+		sub = maskTree{}
+		t[segs[0]] = sub
+	}
+	sub.add(segs[1:])
+}
+
+// checkSet returns error if a field set in m isn't covered by t, prefix is path of m.
+func (t maskTree) checkSet(m protoreflect.Message, prefix string) error {
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := string(fd.Name())
+		sub, ok := t[name]
+		switch {
+		case !ok:
+			err = fmt.Errorf("Field %s%s is set but not in update mask", prefix, name)
+		case sub != nil:
+			err = sub.checkSet(v.Message(), prefix+name+".")
+		}
+		return err == nil
+	})
+	return err
+}
@@ -0,0 +1,35 @@
+package swiffy
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateFieldMask(t *testing.T) {
+	opt := &Options{ValidateFieldMask: true, AllowBulk: true}
+	echo := NewServiceHandler(echoService{}, opt)
+	ingest := NewServiceHandler(ingestService{}, opt)
+	for _, c := range []struct {
+		name   string
+		h      http.Handler
+		target string
+		body   string
+		status int
+		want   string
+	}{
+		{"valid", echo, "/?method=Echo", `{"name":"a","updateMask":{"paths":["name"]}}`, 200, `{"name":"a","updateMask":{"paths":["name"]}}`},
+		{"no mask", echo, "/?method=Echo", `{"name":"a","count":"1"}`, 200, `{"name":"a","count":"1"}`},
+		{"outside mask", echo, "/?method=Echo", `{"name":"a","count":"1","updateMask":{"paths":["name"]}}`, 400, "Field count is set but not in update mask\n"},
+		{"unknown path", echo, "/?method=Echo", `{"updateMask":{"paths":["nope"]}}`, 400, `Invalid update mask path "nope"`},
+		{"bulk", echo, "/?method=Echo", `[{"name":"a","updateMask":{"paths":["name"]}},{"name":"a","count":"1","updateMask":{"paths":["name"]}}]`, 200,
+			`[{"status":200,"result":{"name":"a","updateMask":{"paths":["name"]}}},{"status":400,"error":"Field count is set but not in update mask"}]`},
+		{"ingest", ingest, "/?method=Count", `{"name":"a","updateMask":{"paths":["name"]}}` + "\n" + `{"name":"a","count":"1","updateMask":{"paths":["name"]}}` + "\n", 400,
+			"Field count is set but not in update mask"},
+	} {
+		w := serve(c.h, "POST", c.target, c.body)
+		if w.Code != c.status || !strings.Contains(w.Body.String(), c.want) {
+			t.Errorf("%s got %d %q, want %d %q", c.name, w.Code, w.Body.String(), c.status, c.want)
+		}
+	}
+}
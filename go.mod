@@ -1,6 +1,11 @@
 module yuheng.io/swiffy
 
+go 1.21
+
 require (
-	github.com/golang/protobuf v1.2.0
-	golang.org/x/sync v0.0.0-20181108010431-42b317875d0f // indirect
+	github.com/andybalholm/brotli v1.1.0
+	github.com/golang/protobuf v1.5.4
+	golang.org/x/sync v0.10.0
+	google.golang.org/protobuf v1.33.0
+	sigs.k8s.io/yaml v1.4.0
 )
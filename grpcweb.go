@@ -0,0 +1,222 @@
+package swiffy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// gRPC-Web messages are sent as frames: 1 byte flags, 4 bytes big endian length, then payload.
+// A frame with the MSB of flags set carries trailers (HTTP/1 style header block) instead of
+// a message. See https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-WEB.md
+const (
+	grpcWebFrameHeaderLen = 5
+	grpcWebFlagCompressed = 0x01
+	grpcWebFlagTrailer    = 0x80
+	grpcWebContentType    = "application/grpc-web"
+)
+
+// isGRPCWebRequest tells whether r declares a binary gRPC-Web body, application/grpc-web or
+// application/grpc-web+proto. application/grpc-web-text, base64 encoded, is not supported.
+func isGRPCWebRequest(r *http.Request) bool {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mt == grpcWebContentType || mt == grpcWebContentType+"+proto"
+}
+
+// grpcWebRequested tells whether r is in grpc-web format, by format query parameter or, when
+// that's absent, Content-Type. Form bodies are not parsed, so it's safe on any error path.
+func grpcWebRequested(r *http.Request) bool {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f == "grpc-web"
+	}
+	return isGRPCWebRequest(r)
+}
+
+// readGRPCWebFrame extracts payload of the single data frame in src.
+func readGRPCWebFrame(src []byte) ([]byte, error) {
+	if len(src) >= grpcWebFrameHeaderLen && src[0]&grpcWebFlagTrailer != 0 {
+		return nil, fmt.Errorf("Expecting gRPC-Web data frame, got trailer")
+	}
+	payload, err := readGRPCFrame(src)
+	if err != nil {
+		return nil, fmt.Errorf("gRPC-Web %v", err)
+	}
+	return payload, nil
+}
+
+// readGRPCFrame extracts payload of the single uncompressed gRPC message frame in src.
+func readGRPCFrame(src []byte) ([]byte, error) {
+	if len(src) < grpcWebFrameHeaderLen {
+		return nil, fmt.Errorf("frame too short, %d bytes", len(src))
+	}
+	if flags := src[0]; flags&grpcWebFlagCompressed != 0 {
+		return nil, fmt.Errorf("compressed frame is not supported")
+	} else if flags != 0 {
+		return nil, fmt.Errorf("invalid frame flags %#x", flags)
+	}
+	n := binary.BigEndian.Uint32(src[1:grpcWebFrameHeaderLen])
+	payload := src[grpcWebFrameHeaderLen:]
+	if uint64(len(payload)) != uint64(n) {
+		return nil, fmt.Errorf("frame length mismatch, header %d, actual %d", n, len(payload))
+	}
+	return payload, nil
+}
+
+func writeGRPCWebFrame(w io.Writer, flags byte, payload []byte) error {
+	var hdr [grpcWebFrameHeaderLen]byte
+	hdr[0] = flags
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(payload)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// writeGRPCWebTrailer writes trailer frame carrying grpc-status and grpc-message.
+func writeGRPCWebTrailer(w io.Writer, code int, msg string) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "grpc-status:%d\r\n", code)
+	if msg != "" {
+		fmt.Fprintf(&b, "grpc-message:%s\r\n", grpcPercentEncode(msg))
+	}
+	return writeGRPCWebFrame(w, grpcWebFlagTrailer, b.Bytes())
+}
+
+// writeGRPCWebError writes a trailers-only gRPC-Web response, gRPC-Web reports errors in
+// trailers so HTTP status is always 200.
+func writeGRPCWebError(w http.ResponseWriter, contentType string, status int, msg string) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(200)
+	writeGRPCWebTrailer(w, grpcCodeFromHTTP(status), msg)
+}
+
+// grpcPercentEncode encodes grpc-message as required by gRPC spec: bytes outside printable
+// ASCII and '%' itself are percent encoded.
+func grpcPercentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// grpcCodeFromHTTP maps HTTP status to the closest gRPC status code.
+func grpcCodeFromHTTP(status int) int {
+	switch {
+	case status >= 200 && status < 300:
+		return 0 // OK
+	case status == 400:
+		return 3 // InvalidArgument
+	case status == 401:
+		return 16 // Unauthenticated
+	case status == 403:
+		return 7 // PermissionDenied
+	case status == 404:
+		return 5 // NotFound
+	case status == 409:
+		return 10 // Aborted
+	case status == 429:
+		return 8 // ResourceExhausted
+	case status == 499:
+		return 1 // Canceled
+	case status == 501:
+		return 12 // Unimplemented
+	case status == 503:
+		return 14 // Unavailable
+	case status == 504:
+		return 4 // DeadlineExceeded
+	case status == 500:
+		return 13 // Internal
+	default:
+		return 2 // Unknown
+	}
+}
+
+// httpFromGRPCCode maps gRPC status code to HTTP status, as grpc-gateway does.
+func httpFromGRPCCode(code int) int {
+	switch code {
+	case 0: // OK
+		return 200
+	case 1: // Canceled
+		return 499
+	case 3, 9, 11: // InvalidArgument, FailedPrecondition, OutOfRange
+		return 400
+	case 4: // DeadlineExceeded
+		return 504
+	case 5: // NotFound
+		return 404
+	case 6, 10: // AlreadyExists, Aborted
+		return 409
+	case 7: // PermissionDenied
+		return 403
+	case 8: // ResourceExhausted
+		return 429
+	case 12: // Unimplemented
+		return 501
+	case 14: // Unavailable
+		return 503
+	case 16: // Unauthenticated
+		return 401
+	default: // Unknown, Internal, DataLoss
+		return 500
+	}
+}
+
+// GRPCErrorMapper is an ErrorMapper for errors created by google.golang.org/grpc/status, or
+// anything with a GRPCStatus() method returning such status. Code is mapped to HTTP status,
+// NotFound to 404, PermissionDenied to 403 etc., and the status proto, carrying code, message
+// and details, is encoded as error message. Status is inspected by reflection so swiffy
+// doesn't depend on grpc. Errors wrapping such status, like fmt.Errorf("...: %w", err), are
+// unwrapped in the order of errors.As.
+func GRPCErrorMapper(err error) error {
+	st, ok := grpcStatus(err)
+	if !ok {
+		return err
+	}
+	code, msg := st.MethodByName("Code"), st.MethodByName("Message")
+	if !code.IsValid() || !msg.IsValid() {
+		return err
+	}
+	var details interface{}
+	if p := st.MethodByName("Proto"); p.IsValid() {
+		details = p.Call(nil)[0].Interface()
+	}
+	return Error(httpFromGRPCCode(int(code.Call(nil)[0].Uint())), msg.Call(nil)[0].String(), details)
+}
+
+// grpcStatus returns result of GRPCStatus method of the first error in tree of err having one
+// that returns a non nil status. errors.As can't be used as the status type is unknown.
+func grpcStatus(err error) (reflect.Value, bool) {
+	if err == nil {
+		return reflect.Value{}, false
+	}
+	m := reflect.ValueOf(err).MethodByName("GRPCStatus")
+	if m.IsValid() && m.Type().NumIn() == 0 && m.Type().NumOut() == 1 {
+		st := m.Call(nil)[0]
+		if st.Kind() != reflect.Ptr || !st.IsNil() {
+			return st, true
+		}
+	}
+	switch u := err.(type) {
+	case interface{ Unwrap() error }:
+		return grpcStatus(u.Unwrap())
+	case interface{ Unwrap() []error }:
+		for _, e := range u.Unwrap() {
+			if st, ok := grpcStatus(e); ok {
+				return st, true
+			}
+		}
+	}
+	return reflect.Value{}, false
+}
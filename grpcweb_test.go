@@ -0,0 +1,77 @@
+package swiffy
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"yuheng.io/swiffy/internal/testpb"
+)
+
+func TestGRPCWebContentType(t *testing.T) {
+	h := NewServiceHandler(echoService{}, nil)
+	body := string(grpcFrame(mustMarshal(&testpb.Msg{Name: "a"})))
+	for _, c := range []struct {
+		contentType string
+		grpcWeb     bool
+	}{
+		{"application/grpc-web", true},
+		{"application/grpc-web+proto", true},
+		{"application/grpc-web+proto; charset=utf-8", true},
+		{"application/grpc-web-text", false},
+		{"application/grpc-web-text+proto", false},
+	} {
+		w := serve(h, "POST", "/?method=Echo", body, "Content-Type", c.contentType)
+		if !c.grpcWeb {
+			if w.Code != 400 {
+				t.Errorf("%s got %d, want 400 as not grpc-web", c.contentType, w.Code)
+			}
+			continue
+		}
+		frame, ok := bytes.CutSuffix(w.Body.Bytes(), grpcWebTrailer(0, ""))
+		if !ok {
+			t.Errorf("%s got %q, want OK trailer", c.contentType, w.Body.Bytes())
+			continue
+		}
+		payload, err := readGRPCWebFrame(frame)
+		res := &testpb.Msg{}
+		if err == nil {
+			err = proto.Unmarshal(payload, res)
+		}
+		if w.Code != 200 || err != nil || res.Name != "a" {
+			t.Errorf("%s got %d %q, %v", c.contentType, w.Code, w.Body.Bytes(), err)
+		}
+	}
+}
+
+func TestGRPCWebFrameworkErrors(t *testing.T) {
+	h := NewServiceHandler(echoService{}, nil)
+	for _, c := range []struct {
+		target, body string
+		code         int
+		msg          string
+	}{
+		{"/?method=Nope", "", 5, "Method not found"},
+		{"/?method=Echo", "\x00\x00\x00\x00\x09garbage", 3, ""},
+		{"/?method=Echo&format=grpc-web", "{}", 3, ""},
+	} {
+		w := serve(h, "POST", c.target, c.body, "Content-Type", "application/grpc-web+proto")
+		// Message of decode errors tells decoder details, only status is checked.
+		want := []byte(fmt.Sprintf("grpc-status:%d\r\n", c.code))
+		if c.msg != "" {
+			want = grpcWebTrailer(c.code, c.msg)
+		}
+		if w.Code != 200 || w.Header().Get("Content-Type") != "application/grpc-web+proto" || !bytes.Contains(w.Body.Bytes(), want) {
+			t.Errorf("%s got %d %s %q, want grpc-status %d", c.target, w.Code, w.Header().Get("Content-Type"), w.Body.Bytes(), c.code)
+		}
+	}
+}
+
+// grpcWebTrailer returns trailer frame of status code and msg.
+func grpcWebTrailer(code int, msg string) []byte {
+	var b bytes.Buffer
+	writeGRPCWebTrailer(&b, code, msg)
+	return b.Bytes()
+}
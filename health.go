@@ -0,0 +1,53 @@
+package swiffy
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// LivenessHandler returns an http.Handler for liveness probes like /healthz, it always responds
+// 200, i.e. the process is up and serving HTTP.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte("ok\n"))
+	})
+}
+
+// Readiness is a flag telling whether the server should receive traffic, it's ready unless set
+// otherwise. As an http.Handler it serves readiness probes like /readyz, 200 when ready and
+// 503 when not. Clear it when starting to shut down, and give load balancers a probe period to
+// notice before stopping the server:
+//
+//	ready.SetReady(false)
+//	time.Sleep(drainDelay)
+//	srv.Shutdown(ctx)
+//
+// It's safe for concurrent use, and independent of service handlers.
+type Readiness struct {
+	notReady int32
+}
+
+// SetReady sets the flag.
+func (r *Readiness) SetReady(ready bool) {
+	var v int32
+	if !ready {
+		v = 1
+	}
+	atomic.StoreInt32(&r.notReady, v)
+}
+
+// Ready reports the flag.
+func (r *Readiness) Ready() bool {
+	return atomic.LoadInt32(&r.notReady) == 0
+}
+
+// ServeHTTP implements http.Handler.
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if !r.Ready() {
+		http.Error(w, "not ready", 503)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,156 @@
+package swiffy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Ingest methods take a channel of requests instead of a single one:
+//
+//	func(ctx context.Context, reqs <-chan *requestProto) (*responseProto, error)
+//
+// Request body is newline delimited JSON, one request per line, decoded and fed to the method
+// as it's read, so the whole body is never held in memory. The channel is closed at the end of
+// body. When a line can't be decoded, context of the method is canceled and the channel
+// closed, the request then fails with 400 telling the line number, whatever method returns.
+// A method returning early should return an error, lines not consumed are discarded.
+//
+// A line can't exceed MaxRequestBytes, or defaultMaxIngestLine if that's not set.
+const defaultMaxIngestLine = 1 << 20
+
+// isIngestType tells whether t is a channel of requests an ingest method can take.
+func isIngestType(t reflect.Type) bool {
+	return t.Kind() == reflect.Chan && t.ChanDir()&reflect.RecvDir != 0 && t.Elem().Kind() == reflect.Ptr
+}
+
+// ingestError is a failure reading or decoding body of an ingest request.
+type ingestError struct {
+	status int
+	line   int
+	err    error
+}
+
+func (e *ingestError) Error() string {
+	return fmt.Sprintf("Line %d: %v", e.line, e.err)
+}
+
+func (e *ingestError) HTTPStatus() int {
+	return e.status
+}
+
+// serveIngest serves an ingest method. rb is request passed by the request form value, body
+// is read otherwise.
+func (h *methodHandler) serveIngest(w http.ResponseWriter, r *http.Request, obs *Observation, rb []byte, format string) {
+	if format != "json" {
+		h.opt.httpError(w, r, 400, fmt.Sprintf("Method %s takes newline delimited JSON, not %s", h.name, format))
+		return
+	}
+	var body io.Reader = bytes.NewReader(rb)
+	if rb == nil {
+		if err := h.opt.checkContentType(r, "ndjson"); err != nil {
+			h.opt.httpError(w, r, 415, err.Error())
+			return
+		}
+		rd, closeBody, err := openBody(r)
+		if err != nil {
+			h.opt.httpError(w, r, err.(WithHTTPStatus).HTTPStatus(), err.Error())
+			return
+		}
+		defer closeBody()
+		body = rd
+	}
+	cr := &countingReader{ReadCloser: io.NopCloser(body)}
+	defer func() { obs.RequestBytes = atomic.LoadInt64(&cr.n) }()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	reqs := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, reflect.PtrTo(h.reqType)), 0)
+	var wg sync.WaitGroup
+	var readErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer reqs.Close()
+		if readErr = h.feedIngest(ctx, cr, reqs); readErr != nil {
+			cancel()
+		}
+	}()
+	res, err := h.call(ctx, reqs.Interface())
+	// Stop feeding in case method returned before reading all requests.
+	cancel()
+	wg.Wait()
+
+	setServerTiming(w, ctx)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if readErr != nil {
+		h.opt.httpError(w, r, readErr.(WithHTTPStatus).HTTPStatus(), readErr.Error())
+		return
+	}
+	if err != nil {
+		h.writeError(w, r, err, format)
+		return
+	}
+	if err := h.encode(w, r, 200, res, format); err != nil {
+		h.opt.httpError(w, r, 500, fmt.Sprintf("Encode response failed, %v", err))
+	}
+}
+
+// lineScanner returns a scanner of lines of body, each up to MaxRequestBytes, or
+// defaultMaxIngestLine if that's not set.
+func (h *methodHandler) lineScanner(body io.Reader) *bufio.Scanner {
+	max := h.opt.MaxRequestBytes
+	if max <= 0 {
+		max = defaultMaxIngestLine
+	}
+	// Scanner takes the larger of max and capacity of buffer as the limit.
+	n := 4096
+	if max < n {
+		n = max
+	}
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 0, n), max)
+	return sc
+}
+
+// feedIngest decodes requests from lines of body and sends them to reqs until end of body or
+// ctx is done. Blank lines are skipped.
+func (h *methodHandler) feedIngest(ctx context.Context, body io.Reader, reqs reflect.Value) error {
+	sc := h.lineScanner(body)
+	done := reflect.ValueOf(ctx.Done())
+	line := 0
+	for sc.Scan() {
+		line++
+		b := bytes.TrimSpace(sc.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		req := reflect.New(h.reqType)
+		if err := h.decode(ctx, req.Interface(), b, "json"); err != nil {
+			return &ingestError{400, line, fmt.Errorf("Decode request failed, %v", err)}
+		}
+		if err := h.transform(req.Interface()); err != nil {
+			return &ingestError{400, line, err}
+		}
+		chosen, _, _ := reflect.Select([]reflect.SelectCase{
+			{Dir: reflect.SelectSend, Chan: reqs, Send: req},
+			{Dir: reflect.SelectRecv, Chan: done},
+		})
+		if chosen == 1 {
+			return nil
+		}
+	}
+	switch err := sc.Err(); {
+	case err == bufio.ErrTooLong:
+		return &ingestError{413, line + 1, fmt.Errorf("Request too large")}
+	case err != nil && ctx.Err() == nil:
+		return &ingestError{400, line + 1, fmt.Errorf("Read request from HTTP body failed, %v", err)}
+	}
+	return nil
+}
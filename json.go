@@ -0,0 +1,242 @@
+package swiffy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// marshalJSON marshals msg by jsonpb, then applies transforms jsonpb doesn't support natively.
+func (c *protoCodec) marshalJSON(msg proto.Message) ([]byte, error) {
+	m := jsonpb.Marshaler{EnumsAsInts: c.enumsAsInts}
+	var b bytes.Buffer
+	if err := m.Marshal(&b, msg); err != nil {
+		return nil, err
+	}
+	if !c.int64AsNumber && !c.canonicalJSON && len(c.flattenAny) == 0 && c.nonFinite == NonFiniteString {
+		return b.Bytes(), nil
+	}
+	// Re-encoding through encoding/json sorts object keys, which also makes the output canonical.
+	// json.Number keeps numbers verbatim.
+	dec := json.NewDecoder(&b)
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if c.int64AsNumber {
+		v = walkProtoJSON(v, proto.MessageReflect(msg), int64AsNumber)
+	}
+	if c.nonFinite != NonFiniteString {
+		var err error
+		v = walkProtoJSON(v, proto.MessageReflect(msg), func(fd protoreflect.FieldDescriptor, v interface{}) interface{} {
+			if fd.Kind() != protoreflect.FloatKind && fd.Kind() != protoreflect.DoubleKind {
+				return v
+			}
+			switch v {
+			case "NaN", "Infinity", "-Infinity":
+			default:
+				return v
+			}
+			switch c.nonFinite {
+			case NonFiniteNull:
+				return nil
+			case NonFiniteZero:
+				return json.Number("0")
+			default:
+				if err == nil {
+					err = fmt.Errorf("Field %s is %s, not allowed in JSON", fd.FullName(), v)
+				}
+				return v
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(c.flattenAny) > 0 {
+		v = flattenAny(v, c.flattenAny)
+	}
+	return json.Marshal(v)
+}
+
+// flattenAny replaces, anywhere in v, JSON of google.protobuf.Any holding one of types, i.e.
+// objects with "@type" of such a type, by JSON of the message it holds: the object without
+// "@type", or the "value" for well-known types which jsonpb renders as such.
+func flattenAny(v interface{}, types map[string]bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = flattenAny(e, types)
+		}
+		url, ok := v["@type"].(string)
+		name := url[strings.LastIndex(url, "/")+1:]
+		if !ok || !types[name] {
+			return v
+		}
+		delete(v, "@type")
+		if wellKnownJSON[protoreflect.FullName(name)] {
+			if inner, ok := v["value"]; ok && len(v) == 1 {
+				return inner
+			}
+		}
+		return v
+	case []interface{}:
+		for i, e := range v {
+			v[i] = flattenAny(e, types)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// NonFiniteMode tells how json responses render NaN and infinite values of float fields.
+type NonFiniteMode int
+
+const (
+	// NonFiniteString renders them as "NaN", "Infinity" and "-Infinity" strings like jsonpb,
+	// which strict JSON parsers may not take for numbers.
+	NonFiniteString NonFiniteMode = iota
+	// NonFiniteNull renders them as null.
+	NonFiniteNull
+	// NonFiniteZero renders them as 0.
+	NonFiniteZero
+	// NonFiniteReject fails encoding, so that client gets 500 instead of the value.
+	NonFiniteReject
+)
+
+// int64AsNumber turns quoted 64-bit integers into JSON numbers.
+func int64AsNumber(fd protoreflect.FieldDescriptor, v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	switch fd.Kind() {
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return json.Number(s)
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if _, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return json.Number(s)
+		}
+	}
+	return v
+}
+
+// wellKnownJSON lists well-known types that have special JSON mapping.
+var wellKnownJSON = map[protoreflect.FullName]bool{
+	"google.protobuf.Any":         true,
+	"google.protobuf.Timestamp":   true,
+	"google.protobuf.Duration":    true,
+	"google.protobuf.FieldMask":   true,
+	"google.protobuf.Struct":      true,
+	"google.protobuf.Value":       true,
+	"google.protobuf.ListValue":   true,
+	"google.protobuf.DoubleValue": true,
+	"google.protobuf.FloatValue":  true,
+	"google.protobuf.Int64Value":  true,
+	"google.protobuf.UInt64Value": true,
+	"google.protobuf.Int32Value":  true,
+	"google.protobuf.UInt32Value": true,
+	"google.protobuf.BoolValue":   true,
+	"google.protobuf.StringValue": true,
+	"google.protobuf.BytesValue":  true,
+}
+
+// walkProtoJSON walks v, a decoded jsonpb output of m, alongside fields set in m, and replaces
+// each scalar field value by fn(field, value).
+// Well-known types have their own JSON mapping and are left untouched.
+func walkProtoJSON(v interface{}, m protoreflect.Message, fn func(protoreflect.FieldDescriptor, interface{}) interface{}) interface{} {
+	obj, ok := v.(map[string]interface{})
+	if !ok || wellKnownJSON[m.Descriptor().FullName()] {
+		return v
+	}
+	m.Range(func(fd protoreflect.FieldDescriptor, fv protoreflect.Value) bool {
+		for _, key := range []string{fd.JSONName(), string(fd.Name())} {
+			if jv, ok := obj[key]; ok {
+				obj[key] = walkFieldJSON(jv, fd, fv, fn)
+				break
+			}
+		}
+		return true
+	})
+	return obj
+}
+
+func walkFieldJSON(v interface{}, fd protoreflect.FieldDescriptor, fv protoreflect.Value, fn func(protoreflect.FieldDescriptor, interface{}) interface{}) interface{} {
+	switch {
+	case fd.IsList():
+		arr, ok := v.([]interface{})
+		list := fv.List()
+		if !ok || len(arr) != list.Len() {
+			return v
+		}
+		for i := range arr {
+			arr[i] = walkValueJSON(arr[i], fd, list.Get(i), fn)
+		}
+		return arr
+	case fd.IsMap():
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		fv.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+			if jv, ok := obj[k.String()]; ok {
+				obj[k.String()] = walkValueJSON(jv, fd.MapValue(), mv, fn)
+			}
+			return true
+		})
+		return obj
+	default:
+		return walkValueJSON(v, fd, fv, fn)
+	}
+}
+
+func walkValueJSON(v interface{}, fd protoreflect.FieldDescriptor, fv protoreflect.Value, fn func(protoreflect.FieldDescriptor, interface{}) interface{}) interface{} {
+	if fd.Message() != nil {
+		return walkProtoJSON(v, fv.Message(), fn)
+	}
+	return fn(fd, v)
+}
+
+// extractJSONPath returns the value at path in JSON src. path is a JSON pointer, like
+// /payload/request, or a single top level key if it doesn't start with /.
+func extractJSONPath(src []byte, path string) ([]byte, error) {
+	tokens := []string{path}
+	if strings.HasPrefix(path, "/") {
+		tokens = strings.Split(path[1:], "/")
+		for i, t := range tokens {
+			tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+		}
+	}
+	cur := json.RawMessage(src)
+	for _, t := range tokens {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(cur, &obj); err == nil {
+			v, ok := obj[t]
+			if !ok {
+				return nil, fmt.Errorf("No %s in request", path)
+			}
+			cur = v
+			continue
+		}
+		var arr []json.RawMessage
+		if err := json.Unmarshal(cur, &arr); err != nil {
+			return nil, fmt.Errorf("No %s in request, %v", path, err)
+		}
+		i, err := strconv.Atoi(t)
+		if err != nil || i < 0 || i >= len(arr) {
+			return nil, fmt.Errorf("No %s in request", path)
+		}
+		cur = arr[i]
+	}
+	return cur, nil
+}
@@ -0,0 +1,34 @@
+package swiffy
+
+import (
+	"testing"
+)
+
+func TestNonFiniteFloats(t *testing.T) {
+	// Top level, nested, repeated and map fields.
+	req := `{"ratio":"NaN","sub":{"ratio":"Infinity"},"values":[1.5,"-Infinity"],"scores":{"k":"NaN"}}`
+	finite := `{"ratio":0.5,"sub":{"ratio":1},"values":[1.5],"scores":{"k":2}}`
+	for _, c := range []struct {
+		mode   NonFiniteMode
+		req    string
+		status int
+		want   string
+	}{
+		{NonFiniteString, req, 200, `{"ratio":"NaN","sub":{"ratio":"Infinity"},"values":[1.5,"-Infinity"],"scores":{"k":"NaN"}}`},
+		{NonFiniteNull, req, 200, `{"ratio":null,"scores":{"k":null},"sub":{"ratio":null},"values":[1.5,null]}`},
+		{NonFiniteZero, req, 200, `{"ratio":0,"scores":{"k":0},"sub":{"ratio":0},"values":[1.5,0]}`},
+		{NonFiniteReject, req, 500, "Encode response failed, Field swiffy.test.Msg.ratio is NaN, not allowed in JSON\n"},
+		{NonFiniteReject, finite, 200, `{"ratio":0.5,"scores":{"k":2},"sub":{"ratio":1},"values":[1.5]}`},
+	} {
+		w := serve(NewServiceHandler(echoService{}, &Options{NonFiniteFloats: c.mode}), "POST", "/?method=Echo", c.req)
+		if w.Code != c.status || w.Body.String() != c.want {
+			t.Errorf("mode %d with %s got %d %q, want %d %q", c.mode, c.req, w.Code, w.Body.String(), c.status, c.want)
+		}
+	}
+
+	// Nested alone is found as well.
+	h := NewServiceHandler(echoService{}, &Options{NonFiniteFloats: NonFiniteReject})
+	if w := serve(h, "POST", "/?method=Echo", `{"items":[{"values":["Infinity"]}]}`); w.Code != 500 {
+		t.Errorf("nested in repeated message got %d %q, want 500", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,118 @@
+package swiffy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// isMultipartMixed tells whether r has a multipart/mixed body.
+func isMultipartMixed(r *http.Request) bool {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mt == "multipart/mixed"
+}
+
+// multipartToJSON converts multipart/mixed body of r, each part a JSON object, to JSON of request
+// of h with parts as elements of its repeated message field, see Options.MultipartMixed. Each
+// part is decoded on its own first, so that a bad one is reported by index.
+func (h *methodHandler) multipartToJSON(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if params["boundary"] == "" {
+		return nil, Error(400, "multipart/mixed body without boundary", nil)
+	}
+	m, ok := reflect.New(h.reqType).Interface().(proto.Message)
+	if !ok {
+		return nil, Error(400, "multipart/mixed body requires proto request", nil)
+	}
+	fd, err := repeatedMessageField(proto.MessageReflect(m).Descriptor(), h.opt.MultipartField)
+	if err != nil {
+		return nil, Error(400, fmt.Sprintf("Request can't be multipart, %v", err), nil)
+	}
+	rb, err := h.opt.readBody(w, r)
+	if err != nil {
+		return nil, err
+	}
+	mr := multipart.NewReader(bytes.NewReader(rb), params["boundary"])
+	parts := []json.RawMessage{}
+	list := proto.MessageReflect(m).Mutable(fd).List()
+	for i := 0; ; i++ {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, Error(400, fmt.Sprintf("Read part %d failed, %v", i, err), nil)
+		}
+		pb, err := ioutil.ReadAll(p)
+		if err != nil {
+			return nil, Error(400, fmt.Sprintf("Read part %d failed, %v", i, err), nil)
+		}
+		ct := p.Header.Get("Content-Type")
+		if !isJSONPart(ct) {
+			return nil, Error(415, fmt.Sprintf("Part %d has unsupported Content-Type %s", i, ct), nil)
+		}
+		if pb, err = toUTF8(ct, pb); err != nil {
+			return nil, Error(415, fmt.Sprintf("Part %d: %v", i, err), nil)
+		}
+		if len(bytes.TrimSpace(pb)) == 0 {
+			return nil, Error(400, fmt.Sprintf("Part %d is empty", i), nil)
+		}
+		elem := proto.MessageV1(list.NewElement().Message().Interface())
+		if err := h.decode(r.Context(), elem, pb, "json"); err != nil {
+			return nil, Error(400, fmt.Sprintf("Decode part %d failed, %v", i, err), nil)
+		}
+		// jsonpb stops at the end of the first value, ignoring what follows.
+		var part bytes.Buffer
+		if err := json.Compact(&part, pb); err != nil {
+			return nil, Error(400, fmt.Sprintf("Decode part %d failed, %v", i, err), nil)
+		}
+		parts = append(parts, part.Bytes())
+	}
+	return json.Marshal(map[string]interface{}{fd.JSONName(): parts})
+}
+
+// isJSONPart tells whether a part of Content-Type ct is JSON, a part without one is taken as JSON.
+func isJSONPart(ct string) bool {
+	if ct == "" {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	return err == nil && (mt == "application/json" || strings.HasSuffix(mt, "+json"))
+}
+
+// repeatedMessageField returns the repeated message field of md named name, or the only one if
+// name is empty.
+func repeatedMessageField(md protoreflect.MessageDescriptor, name string) (protoreflect.FieldDescriptor, error) {
+	if name != "" {
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil || !fd.IsList() || fd.Message() == nil {
+			return nil, fmt.Errorf("%s has no repeated message field %s", md.FullName(), name)
+		}
+		return fd, nil
+	}
+	var found protoreflect.FieldDescriptor
+	for i := 0; i < md.Fields().Len(); i++ {
+		fd := md.Fields().Get(i)
+		if !fd.IsList() || fd.Message() == nil {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%s has more than one repeated message field", md.FullName())
+		}
+		found = fd
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s has no repeated message field", md.FullName())
+	}
+	return found, nil
+}
@@ -0,0 +1,49 @@
+package swiffy
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// multipartMixed returns a multipart/mixed body of parts, each a Content-Type and a body, with
+// its Content-Type.
+func multipartMixed(parts ...string) (string, string) {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	for i := 0; i+1 < len(parts); i += 2 {
+		header := textproto.MIMEHeader{}
+		if parts[i] != "" {
+			header.Set("Content-Type", parts[i])
+		}
+		pw, _ := mw.CreatePart(header)
+		pw.Write([]byte(parts[i+1]))
+	}
+	mw.Close()
+	return b.String(), "multipart/mixed; boundary=" + mw.Boundary()
+}
+
+func TestMultipartMixed(t *testing.T) {
+	h := NewServiceHandler(echoService{}, &Options{MultipartMixed: true})
+	for _, c := range []struct {
+		name   string
+		parts  []string
+		status int
+		want   string
+	}{
+		{"valid", []string{"application/json", `{"name":"a"}`, "", ` {"name": "b"} `}, 200, `{"items":[{"name":"a"},{"name":"b"}]}`},
+		{"invalid", []string{"application/json", `{"name":"a"}`, "application/json", `{"name":`}, 400, "Decode part 1 failed"},
+		{"trailing data", []string{"application/json", `{"name":"x"} junk`}, 400, "Decode part 0 failed"},
+		{"empty", []string{"application/json", " "}, 400, "Part 0 is empty"},
+		{"wrong content type", []string{"text/plain", `{"name":"a"}`}, 415, "Part 0 has unsupported Content-Type text/plain"},
+		{"unsupported charset", []string{"application/json; charset=shift_jis", `{"name":"a"}`}, 415, "Part 0: Unsupported charset shift_jis"},
+	} {
+		body, contentType := multipartMixed(c.parts...)
+		w := serve(h, "POST", "/?method=Echo", body, "Content-Type", contentType)
+		if w.Code != c.status || !strings.HasPrefix(w.Body.String(), c.want) {
+			t.Errorf("%s got %d %q, want %d %q", c.name, w.Code, w.Body.String(), c.status, c.want)
+		}
+	}
+}
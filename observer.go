@@ -0,0 +1,59 @@
+package swiffy
+
+import (
+	"net/http"
+	"time"
+)
+
+// Observation describes a served method request, it's reported to Options.Observer.
+type Observation struct {
+	// Method is name the method is served as.
+	Method string
+	// RequestedFormat is the format parameter sent by client, empty when not given.
+	RequestedFormat string
+	// Format is the effective format after defaults and Content-Type detection, i.e. what
+	// request is decoded from and response encoded in. Empty if request failed before that.
+	Format string
+	// Status is HTTP status of response.
+	Status int
+	// Duration is time spent serving the request.
+	Duration time.Duration
+	// RequestBytes is size of request decoded, after decompression, in whatever format. For
+	// methods taking newline delimited JSON, it's bytes of lines read.
+	RequestBytes int64
+	// ResponseBytes is size of response body written, before compression by
+	// Options.Compression, error responses included.
+	ResponseBytes int64
+
+	// Request bytes decoded, kept for Options.RecentRequests
+	body []byte
+}
+
+// statusWriter records status written to the underlying ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	// Bytes of body written
+	n int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = 200
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
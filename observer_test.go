@@ -0,0 +1,77 @@
+package swiffy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"yuheng.io/swiffy/internal/testpb"
+)
+
+// sleepService sleeps req.Count milliseconds in Sleep.
+type sleepService struct{}
+
+func (sleepService) Sleep(ctx context.Context, req *testpb.Msg) (*testpb.Msg, error) {
+	time.Sleep(time.Duration(req.Count) * time.Millisecond)
+	return req, nil
+}
+
+func TestSlowThreshold(t *testing.T) {
+	var logs bytes.Buffer
+	h := NewServiceHandler(sleepService{}, &Options{SlowThreshold: 30 * time.Millisecond, ErrorLog: log.New(&logs, "", 0)})
+	serve(h, "POST", "/?method=Sleep", `{"count":0}`)
+	if logs.Len() != 0 {
+		t.Errorf("fast request logged: %s", logs.String())
+	}
+	serve(h, "POST", "/?method=Sleep", `{"count":50}`)
+	if !strings.Contains(logs.String(), "Slow request of Sleep") || !strings.Contains(logs.String(), "status 200") {
+		t.Errorf("slow request not logged with method and status: %q", logs.String())
+	}
+
+	// Nothing is logged unless set.
+	logs.Reset()
+	h = NewServiceHandler(sleepService{}, &Options{ErrorLog: log.New(&logs, "", 0)})
+	serve(h, "POST", "/?method=Sleep", `{"count":50}`)
+	if logs.Len() != 0 {
+		t.Errorf("logged without SlowThreshold: %s", logs.String())
+	}
+}
+
+func TestObservationBytes(t *testing.T) {
+	var obs Observation
+	observer := func(r *http.Request, o *Observation) { obs = *o }
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(`{"name":"zipped"}`))
+	zw.Close()
+	for _, c := range []struct {
+		name, target, body string
+		header             []string
+		req, res           int64
+	}{
+		{"json", "/?method=Echo", `{"name":"abc"}`, nil, 14, 14},
+		{"request param", `/?method=Echo&request={"name":"abc"}`, "", nil, 14, 14},
+		{"gzip", "/?method=Echo", gz.String(), []string{"Content-Encoding", "gzip"}, 17, 17},
+		{"error", "/?method=Echo", `{`, nil, 1, int64(len("Decode request failed, unexpected EOF\n"))},
+		{"compressed response", "/?method=Echo", `{"name":"abc"}`, []string{"Accept-Encoding", "gzip"}, 14, 14},
+	} {
+		h := NewServiceHandler(echoService{}, &Options{Observer: observer, Compression: &Compression{}})
+		w := serve(h, "POST", c.target, c.body, c.header...)
+		if obs.RequestBytes != c.req || obs.ResponseBytes != c.res {
+			t.Errorf("%s got %d %q, observed %d request and %d response bytes, want %d and %d",
+				c.name, w.Code, w.Body.String(), obs.RequestBytes, obs.ResponseBytes, c.req, c.res)
+		}
+	}
+
+	// Lines of ingest body.
+	h := NewServiceHandler(ingestService{}, &Options{Observer: observer})
+	body := strings.Repeat(`{"name":"a"}`+"\n", 3)
+	if serve(h, "POST", "/?method=Count", body); obs.RequestBytes != int64(len(body)) || obs.ResponseBytes != int64(len(`{"count":"3"}`)) {
+		t.Errorf("ingest observed %d request and %d response bytes", obs.RequestBytes, obs.ResponseBytes)
+	}
+}
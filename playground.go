@@ -0,0 +1,124 @@
+package swiffy
+
+import (
+	"html/template"
+	"net/http"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+type playgroundField struct {
+	Name string
+	Type string
+	// Input is how the field is edited and put into request JSON: bool, number, string or json.
+	Input string
+}
+
+type playgroundPage struct {
+	Method string
+	Type   string
+	Fields []playgroundField
+}
+
+// servePlayground serves an HTML page with a form for the request message fields, which
+// submits the request as JSON and shows the response.
+func (h *methodHandler) servePlayground(w http.ResponseWriter, r *http.Request) {
+	m, ok := reflect.New(h.reqType).Interface().(proto.Message)
+	if !ok {
+		h.opt.httpError(w, r, 404, "Playground is only available for proto requests")
+		return
+	}
+	md := proto.MessageReflect(m).Descriptor()
+	page := &playgroundPage{Method: h.name, Type: string(md.FullName())}
+	fds := md.Fields()
+	for i := 0; i < fds.Len(); i++ {
+		fd := fds.Get(i)
+		f := playgroundField{Name: fd.JSONName(), Type: fd.Kind().String()}
+		if fd.Message() != nil {
+			f.Type = string(fd.Message().FullName())
+		}
+		switch {
+		case fd.IsList() || fd.IsMap():
+			f.Type, f.Input = fd.Cardinality().String()+" "+f.Type, "json"
+		case fd.Kind() == protoreflect.BoolKind:
+			f.Input = "bool"
+		case fd.Message() != nil:
+			f.Input = "json"
+		case fd.Kind() == protoreflect.Int32Kind, fd.Kind() == protoreflect.Sint32Kind,
+			fd.Kind() == protoreflect.Sfixed32Kind, fd.Kind() == protoreflect.Uint32Kind,
+			fd.Kind() == protoreflect.Fixed32Kind, fd.Kind() == protoreflect.FloatKind,
+			fd.Kind() == protoreflect.DoubleKind:
+			f.Input = "number"
+		default:
+			// 64-bit integers are strings in JSON, as well as enums and bytes.
+			f.Input = "string"
+		}
+		page.Fields = append(page.Fields, f)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := playgroundTemplate.Execute(w, page); err != nil {
+		h.opt.logRequestf(r, "render playground failed, %v", err)
+	}
+}
+
+var playgroundTemplate = template.Must(template.New("playground").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Method}} playground</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+td { padding: 2px 8px; }
+pre { background: #f4f4f4; padding: 1em; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Method}}</h1>
+<p>Request: <code>{{.Type}}</code></p>
+<form id="form">
+<table>
+{{range .Fields}}<tr>
+<td><label for="f-{{.Name}}">{{.Name}}</label></td>
+<td>{{if eq .Input "bool"}}<input type="checkbox" id="f-{{.Name}}" data-name="{{.Name}}" data-input="bool">{{else}}<input type="text" id="f-{{.Name}}" data-name="{{.Name}}" data-input="{{.Input}}" size="60">{{end}}</td>
+<td><code>{{.Type}}</code></td>
+</tr>{{end}}
+</table>
+<button type="submit">Call</button>
+</form>
+<h2>Request</h2><pre id="request"></pre>
+<h2>Response</h2><pre id="response"></pre>
+<script>
+document.getElementById("form").addEventListener("submit", function(e) {
+  e.preventDefault();
+  var req = {};
+  try {
+    document.querySelectorAll("[data-name]").forEach(function(el) {
+      var kind = el.dataset.input;
+      if (kind === "bool") {
+        if (el.checked) req[el.dataset.name] = true;
+        return;
+      }
+      if (el.value === "") return;
+      if (kind === "number") req[el.dataset.name] = Number(el.value);
+      else if (kind === "json") req[el.dataset.name] = JSON.parse(el.value);
+      else req[el.dataset.name] = el.value;
+    });
+  } catch (err) {
+    document.getElementById("response").textContent = "Invalid input: " + err;
+    return;
+  }
+  var body = JSON.stringify(req, null, 2);
+  document.getElementById("request").textContent = body;
+  var params = new URLSearchParams({method: {{.Method}}, format: "json"});
+  fetch(location.pathname + "?" + params, {method: "POST", headers: {"Content-Type": "application/json"}, body: body})
+    .then(function(res) {
+      return res.text().then(function(text) {
+        document.getElementById("response").textContent = res.status + " " + res.statusText + "\n\n" + text;
+      });
+    })
+    .catch(function(err) { document.getElementById("response").textContent = String(err); });
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,26 @@
+package swiffy
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPlaygroundMethodByPath(t *testing.T) {
+	opt := &Options{Playground: true, PathPrefix: "/api/"}
+	mux := http.NewServeMux()
+	RegisterMethods(mux, "/rpc/", echoService{}, &Options{Playground: true})
+	for _, c := range []struct {
+		h      http.Handler
+		target string
+	}{
+		{NewServiceHandler(echoService{}, opt), "/api/echo?playground=1"},
+		{NewServiceHandler(echoService{}, opt), "/api/?method=Echo&playground=1"},
+		{mux, "/rpc/echo?playground=1"},
+	} {
+		w := serve(c.h, "GET", c.target, "")
+		if w.Code != 200 || !strings.Contains(w.Body.String(), "<h1>Echo</h1>") {
+			t.Errorf("%s got %d, page without method Echo:\n%s", c.target, w.Code, w.Body.String())
+		}
+	}
+}
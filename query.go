@@ -0,0 +1,184 @@
+package swiffy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// reservedParams are query parameters swiffy uses itself, they are never decoded into requests.
+var reservedParams = []string{"method", "format", "request", "wait", "playground", "debug"}
+
+// queryToJSON converts query values to JSON of message md, so that it can be decoded by jsonpb.
+// Keys are field names, either proto or JSON name, dotted for fields of nested messages, e.g.
+// filter.name=foo. Repeated keys fill repeated fields. Map fields are not supported. At most one
+// field of a oneof can be given, parameters setting different fields of it are rejected.
+func queryToJSON(md protoreflect.MessageDescriptor, values url.Values, reserved []string) ([]byte, error) {
+	skip := map[string]bool{}
+	for _, k := range reserved {
+		skip[k] = true
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		if !skip[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	obj := map[string]interface{}{}
+	for _, key := range keys {
+		if err := setQueryValue(obj, md, key, values[key]); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(obj)
+}
+
+func setQueryValue(obj map[string]interface{}, md protoreflect.MessageDescriptor, key string, vals []string) error {
+	path := strings.Split(key, ".")
+	for i, name := range path {
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = md.Fields().ByJSONName(name)
+		}
+		if fd == nil {
+			return fmt.Errorf("Unknown parameter %s", key)
+		}
+		if fd.IsMap() {
+			return fmt.Errorf("Map field %s can't be set by parameter", key)
+		}
+		if od := fd.ContainingOneof(); od != nil && !od.IsSynthetic() {
+			// Only one arm of a oneof can be set, by any number of parameters under it.
+			for j := 0; j < od.Fields().Len(); j++ {
+				if f := od.Fields().Get(j); f != fd {
+					if _, ok := obj[f.JSONName()]; ok {
+						return fmt.Errorf("Parameter %s conflicts with %s of oneof %s", key, f.Name(), od.Name())
+					}
+				}
+			}
+		}
+		if i < len(path)-1 {
+			if fd.Message() == nil || fd.IsList() {
+				return fmt.Errorf("Unknown parameter %s", key)
+			}
+			child, ok := obj[fd.JSONName()].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				obj[fd.JSONName()] = child
+			}
+			obj, md = child, fd.Message()
+			continue
+		}
+		if fd.IsList() {
+			arr := make([]interface{}, len(vals))
+			for j, s := range vals {
+				v, err := queryValue(fd, s)
+				if err != nil {
+					return fmt.Errorf("Invalid parameter %s, %v", key, err)
+				}
+				arr[j] = v
+			}
+			obj[fd.JSONName()] = arr
+			return nil
+		}
+		if len(vals) > 1 {
+			return fmt.Errorf("Multiple values of parameter %s", key)
+		}
+		v, err := queryValue(fd, vals[0])
+		if err != nil {
+			return fmt.Errorf("Invalid parameter %s, %v", key, err)
+		}
+		obj[fd.JSONName()] = v
+	}
+	return nil
+}
+
+// queryValue converts s to the JSON value jsonpb expects for field fd.
+func queryValue(fd protoreflect.FieldDescriptor, s string) (interface{}, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return strconv.ParseBool(s)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, err
+		}
+		return json.Number(s), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if _, err := strconv.ParseUint(s, 10, 64); err != nil {
+			return nil, err
+		}
+		return json.Number(s), nil
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		switch s {
+		case "NaN", "Infinity", "-Infinity":
+			return s, nil
+		}
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return nil, err
+		}
+		return json.Number(s), nil
+	case protoreflect.EnumKind:
+		if _, err := strconv.ParseInt(s, 10, 32); err == nil {
+			return json.Number(s), nil
+		}
+		return s, nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		// Wrapper types are JSON encoded as the value they wrap.
+		if md := fd.Message(); strings.HasPrefix(string(md.FullName()), "google.protobuf.") && strings.HasSuffix(string(md.Name()), "Value") {
+			if vfd := md.Fields().ByName("value"); vfd != nil {
+				return queryValue(vfd, s)
+			}
+		}
+		// Others like Timestamp, Duration take their JSON string form.
+		return s, nil
+	default:
+		// string, and bytes in base64.
+		return s, nil
+	}
+}
+
+// formToJSON converts form-urlencoded body of r, in the same way as queryToJSON, to JSON of
+// request of h. It's how format form is decoded.
+func (h *methodHandler) formToJSON(r *http.Request) ([]byte, error) {
+	if !isFormBody(r) {
+		return nil, Error(415, "Format form requires application/x-www-form-urlencoded body", nil)
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, Error(400, fmt.Sprintf("Parse form failed, %v", err), nil)
+	}
+	if n := h.opt.MaxRequestBytes; n > 0 && len(r.PostForm.Encode()) > n {
+		return nil, Error(413, "Request too large", nil)
+	}
+	m, ok := reflect.New(h.reqType).Interface().(proto.Message)
+	if !ok {
+		return nil, Error(400, "Format form requires proto request", nil)
+	}
+	rb, err := queryToJSON(proto.MessageReflect(m).Descriptor(), r.PostForm, h.opt.reservedParams())
+	if err != nil {
+		return nil, Error(400, err.Error(), nil)
+	}
+	return rb, nil
+}
+
+// isFormBody tells whether r has a form-urlencoded body.
+func isFormBody(r *http.Request) bool {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mt == "application/x-www-form-urlencoded"
+}
+
+// decodeBase64URL decodes base64url s, with or without padding.
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,87 @@
+package swiffy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaxQueryBytes(t *testing.T) {
+	request := func(n int) string { return `request={"name":"` + strings.Repeat("a", n) + `"}` }
+	name := func(n int) string { return "name=" + strings.Repeat("a", n) }
+	for _, c := range []struct {
+		name   string
+		opt    *Options
+		query  string
+		status int
+	}{
+		{"within", &Options{MaxQueryBytes: 64}, request(20), 200},
+		{"beyond", &Options{MaxQueryBytes: 64}, request(100), 414},
+		{"disabled", &Options{MaxQueryBytes: -1, DecodeQuery: true}, name(20 << 10), 200},
+		{"within default", &Options{DecodeQuery: true}, name(7 << 10), 200},
+		{"beyond default", &Options{DecodeQuery: true}, name(9 << 10), 414},
+		{"no default without DecodeQuery", &Options{}, request(20 << 10), 200},
+	} {
+		w := serve(NewServiceHandler(echoService{}, c.opt), "GET", "/?method=Echo&"+c.query, "")
+		if w.Code != c.status {
+			t.Errorf("%s got %d %.100q, want %d", c.name, w.Code, w.Body.String(), c.status)
+		}
+	}
+}
+
+func TestQueryOneof(t *testing.T) {
+	h := NewServiceHandler(echoService{}, &Options{DecodeQuery: true})
+	form := []string{"Content-Type", "application/x-www-form-urlencoded"}
+	for _, c := range []struct {
+		name, method, target, body string
+		status                     int
+		want                       string
+	}{
+		{"query one arm", "GET", "/?method=Echo&text=a", "", 200, `{"text":"a"}`},
+		{"query other arm", "GET", "/?method=Echo&number=2", "", 200, `{"number":"2"}`},
+		{"query no arm", "GET", "/?method=Echo&name=a", "", 200, `{"name":"a"}`},
+		{"query two arms", "GET", "/?method=Echo&text=a&number=2", "", 400, "conflicts with"},
+		{"form one arm", "POST", "/?method=Echo&format=form", "text=a", 200, `{"text":"a"}`},
+		{"form no arm", "POST", "/?method=Echo&format=form", "name=a", 200, `{"name":"a"}`},
+		{"form two arms", "POST", "/?method=Echo&format=form", "number=2&text=a", 400, "conflicts with"},
+	} {
+		var header []string
+		if c.method == "POST" {
+			header = form
+		}
+		w := serve(h, c.method, c.target, c.body, header...)
+		if w.Code != c.status || !strings.Contains(w.Body.String(), c.want) {
+			t.Errorf("%s got %d %q, want %d %q", c.name, w.Code, w.Body.String(), c.status, c.want)
+		}
+	}
+}
+
+func TestStrictQuery(t *testing.T) {
+	h := NewServiceHandler(echoService{}, &Options{StrictQuery: true, ReservedParams: []string{"token"}})
+	for _, c := range []struct {
+		query  string
+		status int
+	}{
+		{"method=Echo", 200},
+		{"method=Echo&format=json&request={}&debug=x", 200},
+		{"method=Echo&token=t", 200},
+		{"method=Echo&name=a", 400},
+		{"method=Echo&Format=json", 400},
+		{"method=Echo&token=t&utm_source=x", 400},
+	} {
+		w := serve(h, "POST", "/?"+c.query, "{}")
+		if w.Code != c.status || c.status == 400 && !strings.HasPrefix(w.Body.String(), "Unexpected query parameter ") {
+			t.Errorf("%s got %d %q, want %d", c.query, w.Code, w.Body.String(), c.status)
+		}
+	}
+	// Form body parameters aren't restricted.
+	if w := serve(h, "POST", "/?method=Echo", "request={}&other=x", "Content-Type", "application/x-www-form-urlencoded"); w.Code != 200 {
+		t.Errorf("form body got %d %q", w.Code, w.Body.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("StrictQuery with DecodeQuery accepted")
+		}
+	}()
+	NewServiceHandler(echoService{}, &Options{StrictQuery: true, DecodeQuery: true})
+}
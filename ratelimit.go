@@ -0,0 +1,36 @@
+package swiffy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// RateLimitError returns an error for a client throttled by a rate limiting Middleware: 429
+// with Retry-After header, and message
+//
+//	{"retry_after_seconds": 30, "limit": 100}
+//
+// encoded in request format like messages of other errors, as a google.protobuf.Struct.
+// retryAfter is rounded up to seconds, limit is the number of requests allowed per window.
+func RateLimitError(retryAfter time.Duration, limit int) error {
+	secs := int64((retryAfter + time.Second - 1) / time.Second)
+	msg := &structpb.Struct{Fields: map[string]*structpb.Value{
+		"retry_after_seconds": structpb.NewNumberValue(float64(secs)),
+		"limit":               structpb.NewNumberValue(float64(limit)),
+	}}
+	h := http.Header{}
+	h.Set("Retry-After", strconv.FormatInt(secs, 10))
+	return &rateLimitError{errorWith: errorWith{status: 429, message: msg}, headers: h}
+}
+
+type rateLimitError struct {
+	errorWith
+	headers http.Header
+}
+
+func (e *rateLimitError) Headers() http.Header {
+	return e.headers
+}
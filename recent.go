@@ -0,0 +1,117 @@
+package swiffy
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// recentMethod is the method name recent requests are served as, see Options.RecentRequests.
+const recentMethod = "__recent__"
+
+// recentBodyLimit caps bytes of request body kept for each recent request.
+const recentBodyLimit = 1 << 10
+
+// recentRequest is a served method request kept by recentLog.
+type recentRequest struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Format     string    `json:"format,omitempty"`
+	Status     int       `json:"status"`
+	DurationMs float64   `json:"durationMs"`
+	// JSON request body with RecentRedactFields redacted, cut to recentBodyLimit bytes. Empty
+	// for other formats.
+	Body      string `json:"body,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// recentLog is a ring buffer of the last requests served.
+type recentLog struct {
+	redact map[string]bool
+
+	mu   sync.Mutex
+	reqs []recentRequest
+	// Index in reqs the next request goes to
+	next int
+	full bool
+}
+
+func newRecentLog(n int, redact []string) *recentLog {
+	l := &recentLog{reqs: make([]recentRequest, n), redact: map[string]bool{}}
+	for _, f := range redact {
+		l.redact[f] = true
+	}
+	return l
+}
+
+// add records request of obs, which was started at start.
+func (l *recentLog) add(start time.Time, obs *Observation) {
+	rr := recentRequest{
+		Time:       start,
+		Method:     obs.Method,
+		Format:     obs.Format,
+		Status:     obs.Status,
+		DurationMs: float64(obs.Duration) / float64(time.Millisecond),
+	}
+	if len(obs.body) > 0 {
+		var v interface{}
+		if json.Unmarshal(obs.body, &v) == nil {
+			b, _ := json.Marshal(redactJSON(v, l.redact))
+			if len(b) > recentBodyLimit {
+				b, rr.Truncated = b[:recentBodyLimit], true
+			}
+			rr.Body = string(b)
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reqs[l.next] = rr
+	l.next = (l.next + 1) % len(l.reqs)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns recorded requests, most recent first.
+func (l *recentLog) list() []recentRequest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := l.next
+	if l.full {
+		n = len(l.reqs)
+	}
+	out := make([]recentRequest, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, l.reqs[(l.next-i+len(l.reqs))%len(l.reqs)])
+	}
+	return out
+}
+
+// redactJSON replaces values of object keys in fields, at any depth of v, by "REDACTED".
+func redactJSON(v interface{}, fields map[string]bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if fields[k] {
+				v[k] = "REDACTED"
+			} else {
+				v[k] = redactJSON(e, fields)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = redactJSON(e, fields)
+		}
+	}
+	return v
+}
+
+// serveRecent responds {"requests": [...]} listing recent requests, most recent first.
+func (h *serviceHandler) serveRecent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType(h.opt.ContentTypes, "json"))
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(struct {
+		Requests []recentRequest `json:"requests"`
+	}{h.opt.recent.list()})
+}
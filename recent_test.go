@@ -0,0 +1,73 @@
+package swiffy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recentResponse struct {
+	Requests []recentRequest `json:"requests"`
+}
+
+func TestRecentRequests(t *testing.T) {
+	h := NewServiceHandler(echoService{}, &Options{RecentRequests: 3, RecentRedactFields: []string{"name"}})
+	for i := 0; i < 5; i++ {
+		serve(h, "POST", "/?method=Echo", fmt.Sprintf(`{"count":%d,"sub":{"name":"secret"}}`, i))
+	}
+	serve(h, "POST", "/?method=Echo&format=proto", "")
+	w := serve(h, "GET", "/?method=__recent__", "")
+	var res recentResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != 200 {
+		t.Fatalf("got %d %q, %v", w.Code, w.Body.String(), err)
+	}
+	// The last 3, most recent first, wrapped around the ring.
+	var got []string
+	for _, rr := range res.Requests {
+		got = append(got, rr.Format+" "+rr.Body)
+	}
+	want := []string{
+		"proto ",
+		`json {"count":4,"sub":{"name":"REDACTED"}}`,
+		`json {"count":3,"sub":{"name":"REDACTED"}}`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for _, rr := range res.Requests {
+		if rr.Method != "Echo" || rr.Status != 200 {
+			t.Errorf("got %+v", rr)
+		}
+	}
+
+	// Long bodies are cut.
+	serve(h, "POST", "/?method=Echo", `{"tags":["`+strings.Repeat("a", 2*recentBodyLimit)+`"]}`)
+	json.Unmarshal(serve(h, "GET", "/?method=__recent__", "").Body.Bytes(), &res)
+	if rr := res.Requests[0]; !rr.Truncated || len(rr.Body) != recentBodyLimit {
+		t.Errorf("long body kept as %d bytes, truncated %v", len(rr.Body), rr.Truncated)
+	}
+}
+
+func TestRecentRequestsConcurrent(t *testing.T) {
+	l := newRecentLog(8, nil)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				l.add(time.Now(), &Observation{Method: fmt.Sprint(g), Status: 200})
+				if n := len(l.list()); n > 8 {
+					t.Errorf("listed %d requests", n)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := len(l.list()); n != 8 {
+		t.Errorf("listed %d requests after 800, want 8", n)
+	}
+}
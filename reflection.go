@@ -0,0 +1,93 @@
+package swiffy
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// reflectionMethod is the method name reflection is served as, see Options.Reflection.
+const reflectionMethod = "__reflection__"
+
+type reflectionMethodInfo struct {
+	Name         string `json:"name"`
+	RequestType  string `json:"requestType,omitempty"`
+	ResponseType string `json:"responseType,omitempty"`
+	// Whether method takes newline delimited JSON stream of requests
+	Ingest bool `json:"ingest,omitempty"`
+	// Whether method streams responses
+	Stream bool `json:"stream,omitempty"`
+}
+
+// serveReflection responds
+//
+//	{"methods": [{"name": "Hello", "requestType": "pkg.HelloRequest", ...}], "fileDescriptorSet": {...}}
+//
+// fileDescriptorSet holds files defining request and response types and their dependencies.
+func (h *serviceHandler) serveReflection(w http.ResponseWriter, r *http.Request) {
+	fds := &descriptorpb.FileDescriptorSet{}
+	seen := map[string]bool{}
+	var addFile func(fd protoreflect.FileDescriptor)
+	addFile = func(fd protoreflect.FileDescriptor) {
+		// Messages of legacy generated code have no file.
+		if fd == nil || seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			addFile(imports.Get(i).FileDescriptor)
+		}
+		fds.File = append(fds.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	typeName := func(t reflect.Type) string {
+		if t.Kind() != reflect.Ptr {
+			return ""
+		}
+		m, ok := reflect.New(t.Elem()).Interface().(proto.Message)
+		if !ok {
+			return ""
+		}
+		md := proto.MessageReflect(m).Descriptor()
+		addFile(md.ParentFile())
+		return string(md.FullName())
+	}
+	var methods []reflectionMethodInfo
+	for _, mn := range sortedNames(h.methods) {
+		mh, ok := h.methods[mn].(*methodHandler)
+		if !ok {
+			methods = append(methods, reflectionMethodInfo{Name: mn})
+			continue
+		}
+		methods = append(methods, reflectionMethodInfo{
+			Name:         mn,
+			RequestType:  typeName(reflect.PtrTo(mh.reqType)),
+			ResponseType: typeName(mh.resType),
+			Ingest:       mh.ingest,
+			Stream:       mh.stream,
+		})
+	}
+	rb, err := protojson.Marshal(fds)
+	if err != nil {
+		h.opt.httpError(w, r, 500, "Encode descriptors failed, "+err.Error())
+		return
+	}
+	buf := newResponseBuffer()
+	buf.limit = h.opt.MaxResponseBytes
+	if err := json.NewEncoder(buf).Encode(struct {
+		Methods           []reflectionMethodInfo `json:"methods"`
+		FileDescriptorSet json.RawMessage        `json:"fileDescriptorSet"`
+	}{methods, rb}); err != nil {
+		h.opt.logRequestf(r, "%v", err)
+		h.opt.httpError(w, r, 500, "Encode descriptors failed, "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", contentType(h.opt.ContentTypes, "json"))
+	buf.flush(w)
+}
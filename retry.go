@@ -0,0 +1,75 @@
+package swiffy
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Retry configures server side retries of backend calls of unary methods in
+// Options.IdempotentMethods, for backends wrapping flaky dependencies. A call failing with a
+// retryable error is tried again after a backoff, up to MaxAttempts times in total, unless
+// request context is done by then, in which case the last error is returned. Middlewares run
+// for each attempt. Each attempt gets its own copy of request, unaffected by changes a failed
+// one made.
+//
+// Zero fields take defaults: MaxAttempts 3, Backoff 100ms.
+type Retry struct {
+	MaxAttempts int
+	// Backoff is wait before the second attempt, doubled for each further one.
+	Backoff time.Duration
+	// Retryable tells whether err is worth another attempt, by default errors with method
+	// Temporary() bool returning true, like net.Error, are.
+	Retryable func(err error) bool
+}
+
+// temporary is implemented by errors that tell whether they are transient.
+type temporary interface {
+	Temporary() bool
+}
+
+func (rt *Retry) call(ctx context.Context, h Handler, req interface{}) (interface{}, error) {
+	backoff := rt.backoff()
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		// The last attempt may as well have the original.
+		if m, ok := req.(proto.Message); ok && attempt < rt.maxAttempts() {
+			attemptReq = proto.Clone(m)
+		}
+		res, err := h(ctx, attemptReq)
+		if err == nil || attempt >= rt.maxAttempts() || !rt.retryable(err) {
+			return res, err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return res, err
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (rt *Retry) retryable(err error) bool {
+	if rt.Retryable != nil {
+		return rt.Retryable(err)
+	}
+	e, ok := err.(temporary)
+	return ok && e.Temporary()
+}
+
+func (rt *Retry) maxAttempts() int {
+	if rt.MaxAttempts <= 0 {
+		return 3
+	}
+	return rt.MaxAttempts
+}
+
+func (rt *Retry) backoff() time.Duration {
+	if rt.Backoff <= 0 {
+		return 100 * time.Millisecond
+	}
+	return rt.Backoff
+}
@@ -0,0 +1,130 @@
+package swiffy
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"yuheng.io/swiffy/internal/testpb"
+)
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "try again" }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyService fails the first failures calls of Tag, after adding a tag to request as a
+// careless backend may.
+type flakyService struct {
+	failures int32
+	calls    *int32
+}
+
+func (s flakyService) Tag(ctx context.Context, req *testpb.Msg) (*testpb.Msg, error) {
+	req.Tags = append(req.Tags, "tagged")
+	if atomic.AddInt32(s.calls, 1) <= s.failures {
+		return nil, temporaryError{}
+	}
+	return req, nil
+}
+
+func TestRetry(t *testing.T) {
+	for _, c := range []struct {
+		failures int32
+		status   int
+		calls    int32
+	}{
+		{0, 200, 1},
+		{1, 200, 2},
+		{2, 200, 3},
+		{3, 500, 3},
+	} {
+		var calls int32
+		h := NewServiceHandler(flakyService{failures: c.failures, calls: &calls}, &Options{
+			Retry:             &Retry{Backoff: time.Millisecond},
+			IdempotentMethods: map[string]bool{"Tag": true},
+		})
+		w := serve(h, "POST", "/?method=Tag", `{"name":"a"}`)
+		if w.Code != c.status || calls != c.calls {
+			t.Errorf("%d failures got %d %q after %d calls, want %d after %d", c.failures, w.Code, w.Body.String(), calls, c.status, c.calls)
+		}
+		if c.status == 200 && w.Body.String() != `{"name":"a","tags":["tagged"]}` {
+			t.Errorf("%d failures got %s, request changed by failed attempts", c.failures, w.Body.String())
+		}
+	}
+
+	// Only idempotent methods are retried.
+	var calls int32
+	h := NewServiceHandler(flakyService{failures: 1, calls: &calls}, &Options{Retry: &Retry{Backoff: time.Millisecond}})
+	if w := serve(h, "POST", "/?method=Tag", `{"name":"a"}`); w.Code != 500 || calls != 1 {
+		t.Errorf("non-idempotent got %d after %d calls, want 500 after 1", w.Code, calls)
+	}
+}
+
+// flakyStreamService fails the first call of each method with a temporary error, after
+// consuming requests and sending responses.
+type flakyStreamService struct {
+	calls *int32
+}
+
+func (s flakyStreamService) Repeat(ctx context.Context, req *testpb.Msg, send func(*testpb.Msg) error) error {
+	send(&testpb.Msg{Name: req.Name})
+	if atomic.AddInt32(s.calls, 1) == 1 {
+		return temporaryError{}
+	}
+	return nil
+}
+
+func (s flakyStreamService) Count(ctx context.Context, reqs <-chan *testpb.Msg) (*testpb.Msg, error) {
+	res := &testpb.Msg{}
+	for range reqs {
+		res.Count++
+	}
+	if atomic.AddInt32(s.calls, 1) == 1 {
+		return nil, temporaryError{}
+	}
+	return res, nil
+}
+
+func (s flakyStreamService) Chat(ctx context.Context, recv func() (*testpb.Msg, error), send func(*testpb.Msg) error) error {
+	for {
+		req, err := recv()
+		if err != nil {
+			break
+		}
+		send(req)
+	}
+	if atomic.AddInt32(s.calls, 1) == 1 {
+		return temporaryError{}
+	}
+	return nil
+}
+
+func TestRetryStreamingMethods(t *testing.T) {
+	for _, c := range []struct {
+		method, body string
+		status       int
+		want         string
+	}{
+		{"Repeat", `{"name":"a"}`, 200, `{"name":"a"}` + "\n"},
+		{"Count", `{"name":"a"}` + "\n" + `{"name":"b"}` + "\n", 500, "try again\n"},
+		{"Chat", `{"name":"a"}` + "\n", 200, `{"name":"a"}` + "\n"},
+	} {
+		var calls int32
+		h := NewServiceHandler(flakyStreamService{calls: &calls}, &Options{
+			Retry:             &Retry{Backoff: time.Millisecond},
+			IdempotentMethods: map[string]bool{c.method: true},
+		})
+		w := serve(h, "POST", "/?method="+c.method, c.body)
+		if calls != 1 {
+			t.Errorf("%s called %d times, want 1", c.method, calls)
+		}
+		if w.Code != c.status || w.Body.String() != c.want {
+			t.Errorf("%s got %d %q, want %d %q", c.method, w.Code, w.Body.String(), c.status, c.want)
+		}
+		if c.status == 200 && w.Header().Get("X-Stream-Error") != "try again" {
+			t.Errorf("%s got X-Stream-Error %q, want try again", c.method, w.Header().Get("X-Stream-Error"))
+		}
+	}
+}
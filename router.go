@@ -0,0 +1,112 @@
+package swiffy
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// Router is implemented by routers that mount handlers by path, like http.ServeMux and
+// chi.Router.
+type Router interface {
+	Handle(pattern string, handler http.Handler)
+}
+
+// RouterFunc adapts a function to Router, e.g. for gorilla/mux whose Handle returns a route:
+//
+//	swiffy.RouterFunc(func(path string, h http.Handler) { r.Handle(path, h) })
+type RouterFunc func(pattern string, handler http.Handler)
+
+// Handle implements Router.
+func (f RouterFunc) Handle(pattern string, handler http.Handler) {
+	f(pattern, handler)
+}
+
+// TrailingSlashMode tells how RegisterMethods treats requests with a trailing slash, like
+// /api/hello_world/.
+type TrailingSlashMode int
+
+const (
+	// TrailingSlashStrict registers only the path without trailing slash, the router decides
+	// what happens to the other, usually 404.
+	TrailingSlashStrict TrailingSlashMode = iota
+	// TrailingSlashBoth registers the method under both paths, clients see no difference.
+	TrailingSlashBoth
+	// TrailingSlashRedirect redirects the path with trailing slash to the one without by 308,
+	// which keeps method and body so POST still works, but costs a round trip and some clients
+	// don't follow redirects for POST at all.
+	TrailingSlashRedirect
+)
+
+// RegisterMethods mounts each method of serv as its own route on router, at prefix followed by
+// method name in snake case, e.g. /api/hello_world for HelloWorld with prefix /api/. With
+// Options.MethodMapper, the name it gives follows prefix as is.
+// The method comes from the route so there's no method parameter, others like format work as
+// with NewServiceHandler.
+//
+// Options.NotFoundHandler, if set, is mounted at prefix itself, on http.ServeMux a prefix
+// ending with slash so catches unknown methods under it. Routers needing a wildcard for that,
+// like chi's /api/*, can add it in a RouterFunc.
+func RegisterMethods(router Router, prefix string, serv interface{}, opt *Options) {
+	opt = initOptions(opt)
+	methods := newMethodHandlers(opt, []Service{{Impl: serv}})
+	notFound := http.NotFoundHandler()
+	if opt.NotFoundHandler != nil {
+		notFound = opt.NotFoundHandler
+		router.Handle(prefix, notFound)
+	}
+	for _, mn := range sortedNames(methods) {
+		path := prefix + mn
+		if opt.MethodMapper == nil {
+			path = prefix + camelCaseToUnderscore(mn)
+		}
+		router.Handle(path, methods[mn])
+		switch opt.TrailingSlash {
+		case TrailingSlashBoth:
+			router.Handle(path+"/", exactPath(path+"/", methods[mn], notFound))
+		case TrailingSlashRedirect:
+			router.Handle(path+"/", exactPath(path+"/", trailingSlashRedirect(path), notFound))
+		}
+	}
+}
+
+// exactPath serves requests to path by h, and others by notFound. A pattern ending with slash
+// matches the whole subtree on http.ServeMux, while only path itself is meant. Path is checked as
+// a suffix, routers mounting subrouters may leave their prefix in URL.
+func exactPath(path string, h, notFound http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, path) {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// trailingSlashRedirect redirects to path, keeping the query.
+func trailingSlashRedirect(path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := path
+		if r.URL.RawQuery != "" {
+			u += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, u, http.StatusPermanentRedirect)
+	})
+}
+
+// camelCaseToUnderscore converts a Go name to snake case, keeping acronyms together, e.g.
+// GetHTTPStatus to get_http_status.
+func camelCaseToUnderscore(s string) string {
+	rs := []rune(s)
+	out := make([]rune, 0, len(rs)+4)
+	for i, r := range rs {
+		if unicode.IsUpper(r) {
+			if i > 0 && (!unicode.IsUpper(rs[i-1]) || i+1 < len(rs) && unicode.IsLower(rs[i+1])) && rs[i-1] != '_' {
+				out = append(out, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
@@ -0,0 +1,59 @@
+package swiffy
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRegisterMethodsTrailingSlash(t *testing.T) {
+	for _, c := range []struct {
+		mode TrailingSlashMode
+		path string
+		want int
+	}{
+		{TrailingSlashStrict, "/api/echo", 200},
+		{TrailingSlashStrict, "/api/echo/", 404},
+		{TrailingSlashBoth, "/api/echo/", 200},
+		{TrailingSlashBoth, "/api/echo/anything/else", 404},
+		{TrailingSlashRedirect, "/api/echo/", 308},
+		{TrailingSlashRedirect, "/api/echo/anything/else", 404},
+	} {
+		mux := http.NewServeMux()
+		RegisterMethods(mux, "/api/", echoService{}, &Options{TrailingSlash: c.mode})
+		if w := serve(mux, "POST", c.path, "{}"); w.Code != c.want {
+			t.Errorf("mode %d %s got %d, want %d", c.mode, c.path, w.Code, c.want)
+		}
+	}
+}
+
+func TestRegisterMethodsNotFound(t *testing.T) {
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		w.Write([]byte(`{"error":"not found"}`))
+	})
+	mux := http.NewServeMux()
+	RegisterMethods(mux, "/api/", echoService{}, &Options{NotFoundHandler: notFound, TrailingSlash: TrailingSlashBoth})
+	for _, path := range []string{"/api/nope", "/api/", "/api/echo/anything"} {
+		if w := serve(mux, "POST", path, "{}"); w.Code != 404 || w.Body.String() != `{"error":"not found"}` {
+			t.Errorf("%s got %d %q, want NotFoundHandler", path, w.Code, w.Body.String())
+		}
+	}
+	if w := serve(mux, "POST", "/api/echo", "{}"); w.Code != 200 {
+		t.Errorf("/api/echo got %d", w.Code)
+	}
+}
+
+func TestRegisterMethodsMapper(t *testing.T) {
+	mapper := func(m reflect.Method) (string, bool) {
+		return "v2/EchoIt", m.Name == "Echo"
+	}
+	mux := http.NewServeMux()
+	RegisterMethods(mux, "/api/", echoService{}, &Options{MethodMapper: mapper})
+	if w := serve(mux, "POST", "/api/v2/EchoIt", "{}"); w.Code != 200 {
+		t.Errorf("mapped path got %d", w.Code)
+	}
+	if w := serve(mux, "POST", "/api/v2/_echo_it", "{}"); w.Code != 404 {
+		t.Errorf("snake cased path got %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,168 @@
+package swiffy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// SchemaVersion rejects with 400 requests declaring a proto schema version the server isn't
+// compatible with, telling client which versions are. Versions are dotted numbers like 2 or
+// 2.3, compared component by component, missing ones count as 0.
+//
+// Client declares version either in a header, checked by Handler before anything is read, or
+// in a request field, checked by Middleware on the decoded request.
+type SchemaVersion struct {
+	// Min and Max bound versions accepted, inclusive, empty for no bound.
+	Min string
+	Max string
+	// Header carries version for Handler, X-Schema-Version by default.
+	Header string
+	// Field is name of the request field carrying version for Middleware, string or integer,
+	// schema_version by default.
+	Field string
+	// Required rejects requests not declaring a version, by default they are let through.
+	Required bool
+}
+
+// Handler wraps h to check version in Header of requests.
+func (s *SchemaVersion) Handler(h http.Handler) http.Handler {
+	s.validate()
+	name := s.Header
+	if name == "" {
+		name = "X-Schema-Version"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.check(r.Header.Get(name)); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Middleware implements Middleware, checking version in Field of requests.
+func (s *SchemaVersion) Middleware(h Handler) Handler {
+	s.validate()
+	name := s.Field
+	if name == "" {
+		name = "schema_version"
+	}
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		if err := s.check(versionFromField(req, name)); err != nil {
+			return nil, Error(400, err.Error(), nil)
+		}
+		return h(ctx, req)
+	}
+}
+
+// validate panics if Min or Max is invalid.
+func (s *SchemaVersion) validate() {
+	for _, b := range []string{s.Min, s.Max} {
+		if b != "" {
+			mustParseVersion(b)
+		}
+	}
+}
+
+func (s *SchemaVersion) check(declared string) error {
+	if declared == "" {
+		if s.Required {
+			return fmt.Errorf("Schema version required, %s", s.accepted())
+		}
+		return nil
+	}
+	v, err := parseVersion(declared)
+	if err != nil {
+		return fmt.Errorf("Invalid schema version %q, %s", declared, s.accepted())
+	}
+	if s.Min != "" && compareVersions(v, mustParseVersion(s.Min)) < 0 ||
+		s.Max != "" && compareVersions(v, mustParseVersion(s.Max)) > 0 {
+		return fmt.Errorf("Schema version %s not supported, %s, update client", declared, s.accepted())
+	}
+	return nil
+}
+
+// accepted describes versions accepted, for error messages.
+func (s *SchemaVersion) accepted() string {
+	switch {
+	case s.Min != "" && s.Max != "":
+		return fmt.Sprintf("server accepts %s to %s", s.Min, s.Max)
+	case s.Min != "":
+		return fmt.Sprintf("server accepts %s and later", s.Min)
+	case s.Max != "":
+		return fmt.Sprintf("server accepts up to %s", s.Max)
+	default:
+		return "server accepts any version"
+	}
+}
+
+// versionFromField returns value of string or integer field name of req, empty if unset.
+func versionFromField(req interface{}, name string) string {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return ""
+	}
+	rm := proto.MessageReflect(m)
+	fd := rm.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if fd == nil || fd.IsList() || fd.IsMap() || !rm.Has(fd) {
+		return ""
+	}
+	v := rm.Get(fd)
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return strconv.FormatInt(v.Int(), 10)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+	return ""
+}
+
+func parseVersion(s string) ([]int, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	v := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// mustParseVersion parses a configured version, panicking if it's invalid.
+func mustParseVersion(s string) []int {
+	v, err := parseVersion(s)
+	if err != nil {
+		panic(fmt.Sprintf("SchemaVersion: %v", err))
+	}
+	return v
+}
+
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package swiffy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSchemaVersion(t *testing.T) {
+	versions := []struct {
+		version string
+		ok      bool
+	}{
+		{"2", true},
+		{"2.0", true},
+		{"v2.3", true},
+		{"3.1", true},
+		{"3.1.0", true},
+		{"1.9", false},
+		{"3.1.1", false},
+		{"4", false},
+		{"x", false},
+	}
+	s := &SchemaVersion{Min: "2", Max: "3.1"}
+
+	// In header.
+	h := s.Handler(NewServiceHandler(echoService{}, nil))
+	for _, v := range versions {
+		w := serve(h, "POST", "/?method=Echo", "{}", "X-Schema-Version", v.version)
+		if v.ok && w.Code != 200 || !v.ok && (w.Code != 400 || !strings.Contains(w.Body.String(), "server accepts 2 to 3.1")) {
+			t.Errorf("header %s got %d %q", v.version, w.Code, w.Body.String())
+		}
+	}
+
+	// In request field.
+	h = NewServiceHandler(echoService{}, &Options{Middleware: s.Middleware})
+	for _, v := range versions {
+		w := serve(h, "POST", "/?method=Echo", `{"schemaVersion":"`+v.version+`"}`)
+		if v.ok && w.Code != 200 || !v.ok && (w.Code != 400 || !strings.Contains(w.Body.String(), "server accepts 2 to 3.1")) {
+			t.Errorf("field %s got %d %q", v.version, w.Code, w.Body.String())
+		}
+	}
+	// Integer field under another name.
+	h = NewServiceHandler(echoService{}, &Options{Middleware: (&SchemaVersion{Min: "2", Field: "count"}).Middleware})
+	if w := serve(h, "POST", "/?method=Echo", `{"count":1}`); w.Code != 400 {
+		t.Errorf("integer field 1 got %d, want 400", w.Code)
+	}
+	if w := serve(h, "POST", "/?method=Echo", `{"count":2}`); w.Code != 200 {
+		t.Errorf("integer field 2 got %d, want 200", w.Code)
+	}
+
+	// Undeclared version passes unless required.
+	for _, required := range []bool{false, true} {
+		s := &SchemaVersion{Min: "2", Required: required}
+		want := 200
+		if required {
+			want = 400
+		}
+		if w := serve(s.Handler(NewServiceHandler(echoService{}, nil)), "POST", "/?method=Echo", "{}"); w.Code != want {
+			t.Errorf("no header, required %v, got %d, want %d", required, w.Code, want)
+		}
+		h := NewServiceHandler(echoService{}, &Options{Middleware: s.Middleware})
+		if w := serve(h, "POST", "/?method=Echo", "{}"); w.Code != want {
+			t.Errorf("no field, required %v, got %d, want %d", required, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package swiffy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type serverTimingKey struct{}
+
+// serverTimings collects durations of sub-operations of a request, in order first recorded.
+type serverTimings struct {
+	mu    sync.Mutex
+	names []string
+	durs  map[string]time.Duration
+}
+
+// RecordTiming records in ctx of a method request that sub-operation name, e.g. db, took d, to
+// be reported in Server-Timing header of response when Options.ServerTiming is set. Durations
+// of the same name add up. It does nothing for other contexts.
+func RecordTiming(ctx context.Context, name string, d time.Duration) {
+	t, ok := ctx.Value(serverTimingKey{}).(*serverTimings)
+	if !ok {
+		return
+	}
+	name = timingName(name)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.durs[name]; !ok {
+		t.names = append(t.names, name)
+	}
+	t.durs[name] += d
+}
+
+// StartTiming starts timing sub-operation name, the returned func records time elapsed by
+// RecordTiming, e.g.
+//
+//	defer swiffy.StartTiming(ctx, "cache")()
+func StartTiming(ctx context.Context, name string) func() {
+	start := time.Now()
+	return func() { RecordTiming(ctx, name, time.Since(start)) }
+}
+
+// withServerTimings returns ctx collecting timings for RecordTiming.
+func withServerTimings(ctx context.Context) context.Context {
+	return context.WithValue(ctx, serverTimingKey{}, &serverTimings{durs: map[string]time.Duration{}})
+}
+
+// setServerTiming sets Server-Timing header of w to timings recorded in ctx if any.
+func setServerTiming(w http.ResponseWriter, ctx context.Context) {
+	t, ok := ctx.Value(serverTimingKey{}).(*serverTimings)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.names) == 0 {
+		return
+	}
+	metrics := make([]string, len(t.names))
+	for i, name := range t.names {
+		ms := float64(t.durs[name]) / float64(time.Millisecond)
+		metrics[i] = name + ";dur=" + strconv.FormatFloat(ms, 'f', 3, 64)
+	}
+	w.Header().Set("Server-Timing", strings.Join(metrics, ", "))
+}
+
+// timingName makes name a valid HTTP token, replacing other characters by _.
+func timingName(name string) string {
+	if name == "" {
+		return "_"
+	}
+	return strings.Map(func(c rune) rune {
+		if c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || strings.ContainsRune("!#$%&'*+-.^_`|~", c) {
+			return c
+		}
+		return '_'
+	}, name)
+}
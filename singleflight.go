@@ -0,0 +1,99 @@
+package swiffy
+
+import (
+	"context"
+	"crypto/sha256"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/sync/singleflight"
+)
+
+// Singleflight provides a Middleware that collapses concurrent identical calls into one: while
+// a call of a method is in flight, others of the same method with equal request wait for it and
+// share its result, instead of each reaching backend. It helps expensive reads during cache
+// miss stampedes. Apply it only to idempotent methods, through MethodMiddleware.
+//
+// Requests are equal when their deterministic proto encodings are, requests that aren't protos
+// are never collapsed. Nothing is kept after a call returns, results or errors alike, so the
+// next call goes to backend again. Shared results are seen by all waiters and must not be
+// modified by middlewares or encoders down the line.
+//
+// The call runs with context of the request that started it. If it fails because that request
+// was canceled or timed out, waiters whose own context is still alive call backend themselves.
+type Singleflight struct {
+	// Identity returns who is calling, e.g. user ID an auth middleware put in ctx, calls are only
+	// collapsed among callers of the same identity. Without it all callers share results, so it
+	// must be set when responses depend on caller.
+	Identity func(ctx context.Context) string
+
+	group singleflight.Group
+}
+
+// flightResult is outcome of a collapsed call.
+type flightResult struct {
+	res interface{}
+	err error
+	// Whether err came from context of the leading request.
+	canceled bool
+	// Value recovered when backend panicked.
+	panicked interface{}
+}
+
+// Middleware implements Middleware.
+func (s *Singleflight) Middleware(h Handler) Handler {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		m, ok := req.(proto.Message)
+		if !ok {
+			return h(ctx, req)
+		}
+		b := proto.NewBuffer(nil)
+		b.SetDeterministic(true)
+		if err := b.Marshal(m); err != nil {
+			return h(ctx, req)
+		}
+		var identity string
+		if s.Identity != nil {
+			identity = s.Identity(ctx)
+		}
+		hash := sha256.Sum256(b.Bytes())
+		key := MethodName(ctx) + "\x00" + identity + "\x00" + string(hash[:])
+
+		leader := false
+		ch := s.group.DoChan(key, func() (interface{}, error) {
+			leader = true
+			return callFlight(ctx, h, req), nil
+		})
+		var fr *flightResult
+		select {
+		case r := <-ch:
+			fr = r.Val.(*flightResult)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if fr.panicked != nil {
+			// Panic goes on with the leading request, waiters see a 500 like it.
+			if leader {
+				panic(fr.panicked)
+			}
+			return nil, Error(500, "Internal server error", nil)
+		}
+		if fr.canceled && !leader && ctx.Err() == nil {
+			return h(ctx, req)
+		}
+		return fr.res, fr.err
+	}
+}
+
+// callFlight calls h, recovering a panic, which would otherwise crash the goroutine
+// singleflight runs it in.
+func callFlight(ctx context.Context, h Handler, req interface{}) (fr *flightResult) {
+	fr = &flightResult{}
+	defer func() {
+		if p := recover(); p != nil {
+			fr.panicked = p
+		}
+	}()
+	fr.res, fr.err = h(ctx, req)
+	fr.canceled = fr.err != nil && ctx.Err() != nil
+	return fr
+}
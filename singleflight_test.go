@@ -0,0 +1,88 @@
+package swiffy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"yuheng.io/swiffy/internal/testpb"
+)
+
+type userKey struct{}
+
+// runFlights calls h through s once for each of ctxs concurrently, backend blocking until all
+// calls have arrived at s, and returns times backend was called.
+func runFlights(t *testing.T, s *Singleflight, ctxs []context.Context) int32 {
+	var arrived sync.WaitGroup
+	arrived.Add(len(ctxs))
+	identity := s.Identity
+	s.Identity = func(ctx context.Context) string {
+		defer arrived.Done()
+		if identity == nil {
+			return ""
+		}
+		return identity(ctx)
+	}
+	release := make(chan struct{})
+	var calls int32
+	h := s.Middleware(func(ctx context.Context, req interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &testpb.Msg{Name: req.(*testpb.Msg).Name}, nil
+	})
+	var done sync.WaitGroup
+	for _, ctx := range ctxs {
+		done.Add(1)
+		go func(ctx context.Context) {
+			defer done.Done()
+			res, err := h(ctx, &testpb.Msg{Name: "a"})
+			if err != nil || res.(*testpb.Msg).Name != "a" {
+				t.Errorf("got %v %v", res, err)
+			}
+		}(ctx)
+	}
+	arrived.Wait()
+	// Let the last arrivals join the flight.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	done.Wait()
+	return calls
+}
+
+func TestSingleflight(t *testing.T) {
+	ctxs := make([]context.Context, 10)
+	for i := range ctxs {
+		ctxs[i] = context.Background()
+	}
+	if n := runFlights(t, &Singleflight{}, ctxs); n != 1 {
+		t.Errorf("backend called %d times for identical calls, want 1", n)
+	}
+
+	// Callers are kept apart by identity.
+	for i := range ctxs {
+		ctxs[i] = context.WithValue(context.Background(), userKey{}, []string{"alice", "bob"}[i%2])
+	}
+	s := &Singleflight{Identity: func(ctx context.Context) string { return ctx.Value(userKey{}).(string) }}
+	if n := runFlights(t, s, ctxs); n != 2 {
+		t.Errorf("backend called %d times for 2 identities, want 2", n)
+	}
+}
+
+func TestSingleflightError(t *testing.T) {
+	var calls int32
+	h := (&Singleflight{}).Middleware(func(ctx context.Context, req interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errors.New("failed")
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := h(context.Background(), &testpb.Msg{}); err == nil {
+			t.Error("error not returned")
+		}
+	}
+	if calls != 2 {
+		t.Errorf("backend called %d times, error cached", calls)
+	}
+}
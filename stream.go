@@ -0,0 +1,156 @@
+package swiffy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Streaming methods send any number of responses, instead of returning one:
+//
+//	func(ctx context.Context, req *requestProto, send func(*responseProto) error) error
+//
+// In proto format, responses are written as length delimited frames, each the byte length of
+// the marshaled message as a varint followed by the message, with Content-Type
+// application/x-protobuf-stream, readable by protodelim or Java parseDelimitedFrom. In json
+// format, they're written as newline delimited JSON, Content-Type application/x-ndjson. Other
+// formats get 400.
+//
+// Each response is flushed once sent, send blocks while client is slow to read, and fails
+// when client is gone, i.e. ctx is done. When method fails before sending anything, the error
+// is reported as usual. After that, status is already out, the error is reported in trailer
+// X-Stream-Error instead. Options.MaxResponseBytes caps bytes of all responses together, send
+// fails once the next response would exceed it, and the stream ends with that error.
+
+var streamContentTypes = map[string]string{
+	"proto": "application/x-protobuf-stream",
+	"json":  "application/x-ndjson",
+}
+
+type streamKey struct{}
+
+var errStreamClosed = errors.New("Stream is closed")
+
+// isStreamShape tells whether fnt is of a streaming method.
+func isStreamShape(fnt reflect.Type) bool {
+	if fnt.NumIn() != 3 || fnt.NumOut() != 1 || fnt.Out(0) != errType ||
+		!fnt.In(0).Implements(ctxType) || fnt.In(1).Kind() != reflect.Ptr {
+		return false
+	}
+	send := fnt.In(2)
+	return send.Kind() == reflect.Func && send.NumIn() == 1 && send.NumOut() == 1 &&
+		send.In(0).Kind() == reflect.Ptr && send.Out(0) == errType
+}
+
+// responseStream writes responses of a streaming method to client.
+type responseStream struct {
+	r      *http.Request
+	h      *methodHandler
+	w      http.ResponseWriter
+	format string
+
+	mu   sync.Mutex
+	sent int
+	// Bytes of frames written
+	written int
+	err     error
+}
+
+// sendFunc makes send function of type t taking responses.
+func (s *responseStream) sendFunc(t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func(args []reflect.Value) []reflect.Value {
+		err := s.send(args[0].Interface())
+		ev := reflect.Zero(errType)
+		if err != nil {
+			ev = reflect.ValueOf(&err).Elem()
+		}
+		return []reflect.Value{ev}
+	})
+}
+
+func (s *responseStream) send(res interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.r.Context().Err(); err != nil {
+		return err
+	}
+	var frame []byte
+	switch s.format {
+	case "proto":
+		m, ok := res.(proto.Message)
+		if !ok {
+			return fmt.Errorf("Response %T is not proto", res)
+		}
+		b, err := proto.Marshal(m)
+		if err != nil {
+			return err
+		}
+		frame = append(proto.EncodeVarint(uint64(len(b))), b...)
+	default:
+		buf := newResponseBuffer()
+		if err := s.h.encoder(buf, 200, res, "json"); err != nil {
+			return err
+		}
+		frame = append(bytes.TrimRight(buf.body.Bytes(), "\n"), '\n')
+	}
+	if limit := s.h.opt.MaxResponseBytes; limit > 0 && s.written+len(frame) > limit {
+		s.err = errResponseTooLarge{limit}
+		s.h.opt.logRequestf(s.r, "%v", s.err)
+		return s.err
+	}
+	if s.sent == 0 {
+		s.w.Header().Set("Content-Type", streamContentTypes[s.format])
+		s.w.Header().Set("X-Content-Type-Options", "nosniff")
+		s.w.Header().Set("Trailer", "X-Stream-Error")
+		s.w.WriteHeader(200)
+	}
+	s.sent++
+	s.written += len(frame)
+	if _, err := s.w.Write(frame); err != nil {
+		s.err = err
+		return err
+	}
+	if err := http.NewResponseController(s.w).Flush(); err != nil && err != http.ErrNotSupported {
+		s.err = err
+		return err
+	}
+	return nil
+}
+
+// serveStream calls streaming method with req, writing its responses to w.
+func (h *methodHandler) serveStream(w http.ResponseWriter, r *http.Request, req interface{}, format string) {
+	if _, ok := streamContentTypes[format]; !ok {
+		h.opt.httpError(w, r, 400, fmt.Sprintf("Method %s streams responses in json or proto, not %s", h.name, format))
+		return
+	}
+	s := &responseStream{r: r, h: h, w: w, format: format}
+	ctx := context.WithValue(r.Context(), streamKey{}, s)
+	_, err := h.call(ctx, req)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The stream is cut even if method ignores the failed send.
+	if _, ok := s.err.(errResponseTooLarge); ok && err == nil {
+		err = s.err
+	}
+	// Response is done once method returns, a send kept by method fails.
+	s.err = errStreamClosed
+	if err == nil {
+		return
+	}
+	if s.sent == 0 {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		h.writeError(w, r, err, format)
+		return
+	}
+	_, text := h.errorStatus(r, err)
+	w.Header().Set("X-Stream-Error", text)
+}
@@ -0,0 +1,91 @@
+package swiffy
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+
+	"yuheng.io/swiffy/internal/testpb"
+)
+
+// streamService streams req.Count copies of req.
+type streamService struct{}
+
+func (streamService) Repeat(ctx context.Context, req *testpb.Msg, send func(*testpb.Msg) error) error {
+	for i := int64(0); i < req.Count; i++ {
+		if err := send(&testpb.Msg{Name: req.Name}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ignoring sends that fail.
+func (streamService) Careless(ctx context.Context, req *testpb.Msg, send func(*testpb.Msg) error) error {
+	for i := int64(0); i < req.Count; i++ {
+		send(&testpb.Msg{Name: req.Name})
+	}
+	return nil
+}
+
+func TestStreamMaxResponseBytes(t *testing.T) {
+	var logs bytes.Buffer
+	// Each response is {"name":"abcdefghij"}\n, 20 bytes.
+	h := NewServiceHandler(streamService{}, &Options{MaxResponseBytes: 50, ErrorLog: log.New(&logs, "", 0)})
+	for _, method := range []string{"Repeat", "Careless"} {
+		w := serve(h, "POST", "/?method="+method, `{"name":"abcdefghij","count":10}`)
+		if w.Code != 200 || strings.Count(w.Body.String(), "\n") != 2 {
+			t.Errorf("%s got %d %q, want 2 responses", method, w.Code, w.Body.String())
+		}
+		if e := w.Header().Get("X-Stream-Error"); !strings.Contains(e, "response exceeds 50 bytes") {
+			t.Errorf("%s got X-Stream-Error %q", method, e)
+		}
+	}
+	if !strings.Contains(logs.String(), "response exceeds 50 bytes") {
+		t.Errorf("oversized stream not logged: %s", logs.String())
+	}
+
+	// Within the cap.
+	w := serve(h, "POST", "/?method=Repeat", `{"name":"abcdefghij","count":2}`)
+	if w.Code != 200 || strings.Count(w.Body.String(), "\n") != 2 || w.Header().Get("X-Stream-Error") != "" {
+		t.Errorf("got %d %q, X-Stream-Error %q", w.Code, w.Body.String(), w.Header().Get("X-Stream-Error"))
+	}
+
+	// The first response is too large, status is still to be sent.
+	h = NewServiceHandler(streamService{}, &Options{MaxResponseBytes: 10, ErrorLog: log.New(&logs, "", 0)})
+	if w := serve(h, "POST", "/?method=Repeat", `{"name":"abcdefghij","count":1}`); w.Code != 500 {
+		t.Errorf("got %d %q, want 500", w.Code, w.Body.String())
+	}
+}
+
+func TestBulkMaxResponseBytes(t *testing.T) {
+	h := NewServiceHandler(echoService{}, &Options{AllowBulk: true, MaxResponseBytes: 60, ErrorLog: log.New(&bytes.Buffer{}, "", 0)})
+	if w := serve(h, "POST", "/?method=Echo", `[{"name":"a"}]`); w.Code != 200 {
+		t.Errorf("small bulk got %d %q", w.Code, w.Body.String())
+	}
+	w := serve(h, "POST", "/?method=Echo", `[{"name":"a"},{"name":"b"},{"name":"c"},{"name":"d"}]`)
+	if w.Code != 500 || !strings.Contains(w.Body.String(), "response exceeds 60 bytes") {
+		t.Errorf("oversized bulk got %d %q, want 500", w.Code, w.Body.String())
+	}
+}
+
+func TestReflectionMaxResponseBytes(t *testing.T) {
+	h := NewServiceHandler(echoService{}, &Options{Reflection: true, MaxResponseBytes: 100, ErrorLog: log.New(&bytes.Buffer{}, "", 0)})
+	if w := serve(h, "GET", "/?method=__reflection__", ""); w.Code != 500 {
+		t.Errorf("oversized reflection got %d, want 500", w.Code)
+	}
+}
+
+func TestBulkStreamMethod(t *testing.T) {
+	var logs bytes.Buffer
+	h := NewServiceHandler(streamService{}, &Options{AllowBulk: true, ErrorLog: log.New(&logs, "", 0)})
+	w := serve(h, "POST", "/?method=Repeat", `[{"name":"a","count":1},{"name":"b","count":1}]`)
+	if w.Code != 400 || w.Body.String() != "Bulk request is not supported by method\n" {
+		t.Errorf("got %d %q, want 400", w.Code, w.Body.String())
+	}
+	if logs.Len() > 0 {
+		t.Errorf("unexpected logs: %s", logs.String())
+	}
+}
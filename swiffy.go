@@ -5,19 +5,47 @@
 // From Web app, it can be called as a POST request like /api/foo?method=Bar&format=json and the body is
 // simply JSON that can be handled by github.com/golang/protobuf/jsonpb
 // For such request, the response will be Status 200 and the plain JSON object as result, or
-// any HTTP status code for error conditions.
+// any HTTP status code for error conditions. Instead of body, request can come in request
+// parameter, in query or in a form-urlencoded body like HTML forms post, e.g.
+// request={"name":"foo"}, it's decoded according to format as well.
+//
+// Request bodies compressed with Content-Encoding: gzip are decompressed, other encodings get 415.
+// Textual bodies declared in ISO-8859-1 charset by Content-Type are transcoded to UTF-8, charsets
+// other than that and UTF-8 get 415.
+//
+// Besides json, format can be yaml, proto, text or grpc-web. yaml is json in YAML syntax.
+// grpc-web reads and writes gRPC-Web framed binary protos (5 bytes frame header, and a trailer
+// frame carrying grpc-status), so existing gRPC-Web clients can talk to the handler. Requests
+// with a gRPC-Web Content-Type default to grpc-web format. Format form decodes form-urlencoded
+// body, keys as in query decoding, and responds in json. Format csv takes json requests and
+// renders a repeated message field of responses as CSV rows, see Options.CSVField.
 package swiffy
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
+	"mime"
+	"net"
 	"net/http"
 	"reflect"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 // WithHTTPStatus interface can report an HTTP StatusCode the object associated with.
@@ -33,6 +61,33 @@ type WithMessage interface {
 	Message() interface{}
 }
 
+// WithHeaders interface can report HTTP headers to be sent along with the object. If a response
+// or an error returned from handler implements this interface, its headers are merged into
+// the response, e.g. Location or Link for pagination.
+type WithHeaders interface {
+	Headers() http.Header
+}
+
+// Partial interface can be implemented by responses that may hold only part of the results,
+// e.g. a page of a list. When IsPartial returns true, the response carries X-Has-More: true,
+// and Options.PartialStatus if set, telling clients to paginate.
+type Partial interface {
+	IsPartial() bool
+}
+
+// mergeHeaders adds headers reported by v to w if it implements WithHeaders.
+func mergeHeaders(w http.ResponseWriter, v interface{}) {
+	wh, ok := v.(WithHeaders)
+	if !ok {
+		return
+	}
+	for k, vs := range wh.Headers() {
+		for _, hv := range vs {
+			w.Header().Add(k, hv)
+		}
+	}
+}
+
 type errorWith struct {
 	status  int
 	text    string
@@ -64,13 +119,48 @@ func Error(status int, text string, message interface{}) error {
 	}
 }
 
+// isReportable tells whether err is crafted for clients, i.e. it carries HTTP status or message.
+func isReportable(err error) bool {
+	if _, ok := err.(WithHTTPStatus); ok {
+		return true
+	}
+	_, ok := err.(WithMessage)
+	return ok
+}
+
 // Handler describes generalize form of gRPC style functions swiffy can serve.
 // The actual handler provided to NewServiceHandler can use any types that conforms to encoder/decoder
+// For ingest methods taking <-chan *requestProto, req is that channel.
 type Handler func(ctx context.Context, req interface{}) (res interface{}, err error)
 
 // Middleware wraps a handler and do its processing before or after calling underliring handler.
 type Middleware func(handler Handler) Handler
 
+// ProtoInterceptor is a Middleware body that sees request as proto.Message, next calls the
+// wrapped handler.
+type ProtoInterceptor func(ctx context.Context, req proto.Message, next Handler) (res interface{}, err error)
+
+// ProtoMiddleware creates a Middleware running fn for proto requests. Requests that aren't proto,
+// like the channel taken by ingest methods, bypass fn, or fail with 500 if requireProto is set.
+func ProtoMiddleware(fn ProtoInterceptor, requireProto bool) Middleware {
+	return func(h Handler) Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			m, ok := req.(proto.Message)
+			if !ok {
+				if requireProto {
+					return nil, fmt.Errorf("Request %T is not proto", req)
+				}
+				return h(ctx, req)
+			}
+			return fn(ctx, m, h)
+		}
+	}
+}
+
+// ErrorMapper translates an error returned by method, e.g. into one implementing WithHTTPStatus
+// or WithMessage. It returns err as is when there's no mapping for it.
+type ErrorMapper func(err error) error
+
 // RequestDecoder decodes src into dst.
 type RequestDecoder func(dst interface{}, src []byte, format string) error
 
@@ -82,101 +172,1413 @@ type Options struct {
 	RequestDecoder  RequestDecoder
 	ResponseEncoder ResponseEncoder
 	Middleware      Middleware
+	// MethodMiddleware adds middlewares to the named methods only. A method's own middleware is
+	// applied inside Middleware, i.e. Middleware runs first and sees the whole processing.
+	MethodMiddleware map[string]Middleware
+
+	// SanitizeErrors hides text of errors that implement neither WithHTTPStatus nor WithMessage,
+	// clients get the generic HTTP status text instead, while the real error is logged.
+	// Such errors are often internal details (SQL errors, file paths) that should not leak.
+	SanitizeErrors bool
+	// AllowBulk lets json requests whose body is a top level JSON array call the method once per
+	// element, see serveBulk for the response format. Streaming and async methods reject such
+	// requests with 400. PartialOnDeadline doesn't apply to elements, they fail like others.
+	AllowBulk bool
+	// Int64AsNumber renders 64-bit integer fields as JSON numbers instead of strings jsonpb uses.
+	// Beware JavaScript numbers are doubles, values beyond 2^53 silently lose precision at client.
+	// Decoding always accepts both forms. Only applies to the default ResponseEncoder, as a side
+	// effect of re-encoding, object keys in output are sorted.
+	Int64AsNumber bool
+	// NotFoundHandler, if set, serves requests for methods the service doesn't have, e.g. to reply
+	// a JSON 404 consistent with other responses instead of the default plain text.
+	// RegisterMethods mounts it at its prefix.
+	NotFoundHandler http.Handler
+	// MaxRequestBytes caps size of request when positive, larger requests get 413. For compressed
+	// bodies, the limit applies to decompressed size.
+	MaxRequestBytes int
+	// ReadTimeout limits time to receive request body, form bodies included, when positive, slow
+	// clients get 408 instead of tying up the handler. It relies on http.ResponseController, so
+	// has no effect when the ResponseWriter doesn't support read deadline.
+	ReadTimeout time.Duration
+	// MaxResponseBytes caps size of encoded responses when positive. Responses are buffered and
+	// a response exceeding the cap is replaced by a 500 error, protecting the server from
+	// pathological backends. It caps all responses of a streaming method together, and the
+	// whole array of a bulk request.
+	MaxResponseBytes int
+	// EnumsAsInts renders enum fields as numbers instead of names in JSON, decoding accepts both.
+	// Only applies to the default ResponseEncoder.
+	EnumsAsInts bool
+	// DepsFunc, when set, lets methods take a third argument, i.e.
+	// func(context.Context, *requestProto, Deps) (*responseProto, error), Deps being produced per
+	// request by DepsFunc, which must be like func(context.Context, *http.Request) (Deps, error).
+	// Error from DepsFunc is reported to client as if returned by the method. Methods of the
+	// usual two argument shape are unaffected.
+	DepsFunc interface{}
+	// Envelope, if set, wraps json responses and errors in an envelope with meta fields.
+	Envelope *Envelope
+	// CanonicalJSON makes JSON output canonical, i.e. compact with object keys sorted, including
+	// keys of map fields, so identical responses are byte-identical, e.g. for ETag hashing.
+	// Only applies to the default ResponseEncoder.
+	CanonicalJSON bool
+	// RecoverPanics turns panics of methods (including Middleware) into 500 responses instead of
+	// letting net/http abort the connection.
+	RecoverPanics bool
+	// OnPanic, if set, is called with the recovered value and stack trace when RecoverPanics
+	// recovers a panic, before the response is written. E.g. to report to error tracking.
+	// Recovered panics are logged when it's nil.
+	OnPanic func(ctx context.Context, method string, recovered interface{}, stack []byte)
+	// JSONRPCEnvelope lets requests without method parameter carry the method in a JSON-RPC
+	// style body, {"method": "Bar", "params": {...}}, params being the request.
+	JSONRPCEnvelope bool
+	// ErrorResponder, if set, writes all plain error responses: framework errors like missing
+	// method or decode failure, and backend errors that have no encodable message. By default
+	// they are written as plain text by http.Error.
+	ErrorResponder func(w http.ResponseWriter, r *http.Request, status int, msg string)
+	// MaxWait enables long-polling when positive: a wait parameter, like wait=30s, sets deadline
+	// of context passed to the method, bounded by MaxWait. The method is expected to block until
+	// data is ready or the deadline, and then return, e.g. an empty result.
+	MaxWait time.Duration
+	// AllowedContentTypes, if set, maps formats to media types their request bodies must be
+	// declared by Content-Type, other requests with body get 415. Formats not in the map are not
+	// checked. See DefaultContentTypes.
+	AllowedContentTypes map[string][]string
+	// DecodeQuery builds proto request from query parameters when request has no body, for
+	// simple GET style calls, e.g. ?method=List&filter.name=foo&ids=1&ids=2. Parameters are
+	// named by fields (proto or JSON name), repeated parameters fill repeated fields.
+	// Parameters swiffy uses itself, like method and format, are excluded.
+	DecodeQuery bool
+	// ReservedParams lists more query parameters to exclude from DecodeQuery, in addition to the
+	// ones swiffy uses itself, e.g. parameters consumed by a proxy or a middleware.
+	ReservedParams []string
+	// StrictQuery rejects with 400 requests with query parameters other than the ones swiffy
+	// uses and ReservedParams, which may be client bugs or probing. It can't be set with
+	// DecodeQuery.
+	StrictQuery bool
+	// Playground serves a debug HTML page for each method, at GET ?method=Bar&playground=1, with
+	// a form of request fields that calls the method and shows the response. Meant for internal
+	// tooling, don't turn it on for public endpoints.
+	Playground bool
+	// MethodMapper, if set, decides which public methods of a service are served and under what
+	// method name. By default all are served under their Go names. RegisterMethods takes the
+	// name as path after its prefix, not converted to snake case.
+	MethodMapper func(m reflect.Method) (name string, include bool)
+	// TimeoutHeader names a request header, like X-Request-Timeout, by which clients set their
+	// deadline as a duration, e.g. 2s. It's applied to context passed to the method, bounded by
+	// MaxTimeout when positive. Errors caused by context deadline are reported as 504.
+	TimeoutHeader string
+	MaxTimeout    time.Duration
+	// Prototext handles text format by google.golang.org/protobuf/encoding/prototext instead of
+	// the legacy proto.MarshalText / UnmarshalText. It follows the text format spec more closely
+	// and supports newer features, but its output is deliberately not stable across versions.
+	// Only applies to the default RequestDecoder and ResponseEncoder.
+	Prototext bool
+	// MaxMethods, when positive, is a guardrail against accidentally serving a fat struct:
+	// creating the handler panics if more methods than that are to be served.
+	MaxMethods int
+	// BaseContext, if set, carries service-wide values, like config or clients set up at startup,
+	// into context of every request. Values are looked up in request context first, deadline and
+	// cancellation still come from the request alone.
+	BaseContext context.Context
+	// ContextValues are fixed values, like feature flags or a config snapshot, put into context
+	// of every request before middleware and backend, which read them by ctx.Value(key). Keys
+	// follow rules of context.WithValue, use unexported types of your package to avoid
+	// collisions. Like BaseContext, values in request context take precedence, and
+	// ContextValues take precedence over BaseContext. The map must not be modified once the
+	// handler is created.
+	ContextValues map[interface{}]interface{}
+	// DisableRequestParam rejects requests passed in the request form value with 400, so request
+	// must come in HTTP body, subject to AllowedContentTypes and the like.
+	DisableRequestParam bool
+	// TrailingSlash decides how routes mounted by RegisterMethods treat a trailing slash in path.
+	// Default TrailingSlashStrict matches only the path without it.
+	TrailingSlash TrailingSlashMode
+	// PreFilter, if set, is called first for every method request, before anything is read or
+	// decoded, to inspect the raw request. It returns false to stop processing, having written
+	// its own response. It's the pre-decode counterpart to Middleware. Handlers of
+	// NewServiceHandler call it before even the method is known, so it sees requests for
+	// unknown and reserved methods too. A service with its own Options under
+	// NewMultiServiceHandler gets its PreFilter called once the method is found, by then a form
+	// body is already parsed.
+	PreFilter func(w http.ResponseWriter, r *http.Request) bool
+	// DeprecationWarnings adds a Warning header to the response for each field set in request
+	// that's marked deprecated in proto, nudging clients to migrate.
+	DeprecationWarnings bool
+	// ErrorMapper, if set, translates errors returned by methods before they are reported,
+	// GRPCErrorMapper for example maps gRPC status errors to HTTP statuses.
+	ErrorMapper ErrorMapper
+	// PathPrefix, if set, lets clients name method in path instead of the method parameter:
+	// with handler mounted at /api/v1/ and PathPrefix /api/v1/, /api/v1/hello_world and
+	// /api/v1/HelloWorld call HelloWorld. The method parameter takes precedence when present.
+	PathPrefix string
+	// Observer, if set, is called after each method request is served with what happened, e.g.
+	// to count usage of formats. It's not called for requests that fail to find a method.
+	Observer func(r *http.Request, o *Observation)
+	// Compression, if set, compresses responses with br or gzip as client accepts.
+	Compression *Compression
+	// AsyncMethods names methods served fire-and-forget: once request is decoded, the method is
+	// run in its own goroutine and client gets 202 with empty body right away. Its context
+	// keeps values of request context but not its deadline or cancellation, so the work
+	// outlives the request. Result is discarded, errors and panics are logged.
+	AsyncMethods map[string]bool
+	// ContentTypes overrides Content-Type of responses by format, e.g. "json" to
+	// application/vnd.company+json. It applies to encoded results and error messages, plain
+	// text errors stay text/plain. Only default ResponseEncoder follows it for results.
+	ContentTypes map[string]string
+	// NoContentOnEmpty responds 204 with no body instead of {} when a method returns a proto with
+	// no field set, or nil, which is otherwise served as an empty proto. Clients expecting a body
+	// break on that, so it's better set per Service.
+	// gRPC-Web responses are not affected.
+	NoContentOnEmpty bool
+	// ValidateFieldMask enforces partial update semantics of requests carrying a
+	// google.protobuf.FieldMask: unknown mask paths and fields set outside the mask get 400,
+	// see validateFieldMask.
+	ValidateFieldMask bool
+	// SkipInvalidMethods skips public methods of a service that can't be served, like ones not
+	// taking and returning protos, or taking a third argument without DepsFunc, instead of
+	// panicking, so structs with helper methods can be served. Skipped methods are logged to
+	// ErrorLog.
+	SkipInvalidMethods bool
+	// PreloadLinks adds Link headers hinting clients to preload related resources to successful
+	// responses of methods, keyed by method name. An entry is either a URL, sent as
+	// <URL>; rel=preload, or a complete link value like </app.js>; rel=preload; as=script.
+	PreloadLinks map[string][]string
+	// RetryAfter, when positive, is sent as Retry-After header, rounded up to seconds, with all
+	// 503 responses, e.g. from CircuitBreaker or methods reporting overload, so well behaved
+	// clients back off. A Retry-After set by the error itself via WithHeaders is kept.
+	RetryAfter time.Duration
+	// RequestTransforms are applied in order to every decoded request before it's passed to
+	// the method, each may mutate it, e.g. rewrite fields by A/B testing rules. An error fails
+	// the request with 400. Nil entries are skipped.
+	RequestTransforms []func(req interface{}) error
+	// LengthPrefixProto frames responses in proto format, results and encoded error messages
+	// alike, as length delimited messages: the byte length of the marshaled message as an
+	// unsigned base 128 varint, the way protobuf encodes lengths, followed by the message. It's
+	// the framing of Java writeDelimitedTo and Go protodelim, so clients can parse responses
+	// concatenated on one stream. Requests are not framed. Only applies to the default
+	// ResponseEncoder.
+	LengthPrefixProto bool
+	// Reflection serves method __reflection__, listing methods with their request and response
+	// types, and descriptors of those types as a JSON google.protobuf.FileDescriptorSet, so
+	// tools can discover and call methods. It exposes the API surface, keep it internal.
+	Reflection bool
+	// PartialStatus, if set, is HTTP status of responses implementing Partial that report more
+	// results available, e.g. 206. They are 200 by default, with X-Has-More header either way.
+	PartialStatus int
+	// RequestPath, if set, locates request within json body, so that a gateway envelope like
+	// {"meta": {...}, "payload": {...}} can be decoded by ignoring all but payload. It's a JSON
+	// pointer like /payload, or a top level key. Bodies missing it get 400.
+	RequestPath string
+	// ResponseChecksum sets X-Content-Checksum header of encoded results to their checksum, like
+	// sha256=<hex>, for clients to verify integrity across flaky proxies. It's of the body
+	// before compression by Compression. Responses are buffered to compute it.
+	ResponseChecksum ChecksumAlgorithm
+	// StatusField names an integer field of responses, e.g. status or code, whose value when
+	// nonzero becomes HTTP status of the response, letting methods set status declaratively. A
+	// value outside 200 to 599 is a bug of method, logged and reported as 500. Responses of 204
+	// and 304 go without body.
+	StatusField string
+	// MaxQueryBytes caps length of query string when positive, longer ones get 414 before being
+	// parsed. It defaults to 8KB when DecodeQuery is on, negative means no limit.
+	MaxQueryBytes int
+	// DebugTiming lets requests with debug=timing get response headers X-Decode-Ms,
+	// X-Backend-Ms and X-Encode-Ms, time spent reading and decoding request, in method and
+	// encoding response. It reveals server internals, don't turn it on for public endpoints.
+	DebugTiming bool
+	// LinkMethods names read-only methods whose request can be embedded in a shareable GET URL:
+	// for them, request param of a GET is base64url of the encoded request, padding optional,
+	// e.g. ?method=Get&format=json&request=eyJpZCI6MX0. Invalid base64 gets 400. Other methods,
+	// and other HTTP methods, take request param as is.
+	LinkMethods map[string]bool
+	// RequireHTTPS rejects requests that came in plaintext HTTP with 426, before anything is
+	// read. A request is over HTTPS if it came over TLS, or, when TrustForwardedProto is set, its
+	// last X-Forwarded-Proto is https.
+	RequireHTTPS bool
+	// TrustForwardedProto lets RequireHTTPS believe X-Forwarded-Proto, set it only when behind
+	// a TLS terminating proxy that sets the header and is the only way to reach the handler,
+	// clients could otherwise send the header over plaintext themselves.
+	TrustForwardedProto bool
+	// FlattenAnyTypes names message types, by full name like acme.Payload, whose
+	// google.protobuf.Any values are rendered in json responses as the message itself, without
+	// the {"@type": ...} wrapper jsonpb adds. It suits Any fields that in practice always hold
+	// one known type. Such output doesn't carry the type and can't be decoded back as Any. Only
+	// applies to the default ResponseEncoder, as a side effect of re-encoding, object keys in
+	// output are sorted.
+	FlattenAnyTypes map[string]bool
+	// NonFiniteFloats decides how NaN and infinite values of float and double fields are
+	// rendered in json responses, by default as strings like jsonpb does. Values inside
+	// well-known types, e.g. google.protobuf.DoubleValue, are not affected. Only applies to the
+	// default ResponseEncoder, other modes re-encode output so object keys are sorted.
+	NonFiniteFloats NonFiniteMode
+	// AuditBody, keyed by method name, gets request bytes of the method exactly as they are
+	// about to be decoded, e.g. to archive them for compliance. Whether they came in body or
+	// request param, format is what they're decoded as: json for form and DecodeQuery requests,
+	// which are converted to JSON first. It's called before decoding, so also for requests that
+	// then fail to decode, but not for ones rejected earlier, nor for ingest methods whose body
+	// is streamed. body must not be modified, nor kept after fn returns, copy it instead.
+	AuditBody map[string]func(r *http.Request, format string, body []byte)
+	// CSVField names the repeated message field of responses that format csv renders as rows,
+	// for responses that have more than one. By default it's the only such field, responses
+	// without one can't be rendered as csv and get 500. Requests of format csv are in json.
+	CSVField string
+	// MultipartMixed accepts json requests in multipart/mixed bodies, each part a JSON object
+	// of an element of a repeated message field of request, so large records can be sent as
+	// separate parts. It's the field named by MultipartField, or by default the only repeated
+	// message field. A part failing to decode gets 400 telling its index, starting from 0, one
+	// with Content-Type other than JSON gets 415. MaxRequestBytes applies to the whole body.
+	MultipartMixed bool
+	// MultipartField names the repeated message field MultipartMixed fills.
+	MultipartField string
+	// PartialOnDeadline names methods, like aggregations over several sources, that may return
+	// what they have gathered so far when request deadline hits: if such a method returns both
+	// a result and an error once the deadline is exceeded, the result is served with 200 and
+	// X-Partial: true instead of the error, usually 504.
+	PartialOnDeadline map[string]bool
+	// StrictMethodNames rejects with 400 method parameters not matching [A-Za-z][A-Za-z0-9]*,
+	// before looking them up, so crafted names never reach method lookup or NotFoundHandler.
+	// Reserved methods like __reflection__ are still served. Go methods whose names have
+	// underscores or non-ASCII letters become unreachable.
+	StrictMethodNames bool
+	// IdempotentMethods names methods that are safe to call more than once for one request,
+	// only these are retried by Retry. Streaming, ingest and bidirectional streaming methods
+	// are never retried.
+	IdempotentMethods map[string]bool
+	// Retry, if set, retries failed backend calls of IdempotentMethods, see Retry.
+	Retry *Retry
+	// Version, if set, is served as JSON by method __version__, see VersionInfo. Without it
+	// the method gets 404 like any unknown one.
+	Version *VersionInfo
+	// SlowThreshold, when positive, logs method requests taking longer than that to ErrorLog,
+	// with method, duration and status. Faster requests are not logged.
+	SlowThreshold time.Duration
+	// ErrorHeaders reports errors in X-Error-Code and X-Error-Message headers, besides or
+	// instead of body, see ErrorHeadersMode. gRPC-Web responses carry errors in their own
+	// trailer and are not affected.
+	ErrorHeaders ErrorHeadersMode
+	// GRPCFramedProto takes requests in proto format framed like gRPC messages: a flag byte of
+	// 0, as compression is not supported, and 4 bytes big endian length, followed by the
+	// message. It lets clients reuse gRPC framing code over plain HTTP, malformed frames get
+	// 400. Responses are not framed, see LengthPrefixProto. Only applies to the default
+	// RequestDecoder.
+	GRPCFramedProto bool
+	// RecentRequests, when positive, keeps that many last method requests in memory, with
+	// method, format, status, duration and JSON body cut to 1KB, served by method __recent__
+	// for live debugging. Requests are kept per Options, __recent__ lists those of methods
+	// sharing Options of the endpoint. Bodies may hold sensitive data, keep it internal.
+	RecentRequests int
+	// RecentRedactFields names JSON keys, at any depth, whose values are redacted in bodies
+	// kept by RecentRequests, e.g. password.
+	RecentRedactFields []string
+	// FormatFallback maps a format parameter swiffy doesn't know to the format to serve the
+	// request in, e.g. json for clients newer than the server, or "" to reject it as unknown
+	// formats are by default. Formats of a custom RequestDecoder or ResponseEncoder are unknown
+	// to swiffy too, return them as is to keep them.
+	FormatFallback func(format string) string
+	// ServerTiming reports durations of sub-operations recorded by backends and middlewares
+	// with RecordTiming in Server-Timing header, for browser devtools. Not for streaming
+	// methods, whose headers are sent before the method returns.
+	ServerTiming bool
+	// ErrorLog specifies an optional logger for errors, if nil, the standard logger of log
+	// package is used.
+	ErrorLog *log.Logger
+
+	// Codec behind RequestDecoder when it's the default
+	codec *protoCodec
+	// Recent requests kept for RecentRequests
+	recent *recentLog
+}
+
+func (opt *Options) logf(format string, args ...interface{}) {
+	if opt.ErrorLog != nil {
+		opt.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// httpError replies a plain error message, through ErrorResponder when it's set, or in
+// trailers to grpc-web requests.
+func (opt *Options) httpError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	opt.replyError(w, r, status, msg, opt.requestFormat(r))
+}
+
+// replyError is httpError for a request in format, errors of json requests go in Envelope.
+func (opt *Options) replyError(w http.ResponseWriter, r *http.Request, status int, msg, format string) {
+	opt.setRetryAfter(w, status)
+	if grpcWebRequested(r) {
+		// gRPC-Web clients only understand errors in trailers.
+		writeGRPCWebError(w, contentType(opt.ContentTypes, "grpc-web"), status, msg)
+		return
+	}
+	if opt.setErrorHeaders(w, status, msg) {
+		w.WriteHeader(status)
+		return
+	}
+	if opt.ErrorResponder != nil {
+		opt.ErrorResponder(w, r, status, msg)
+		return
+	}
+	if opt.Envelope != nil && format == "json" {
+		b, _ := json.Marshal(msg)
+		if rb, err := opt.Envelope.wrap(r, "error", b); err == nil {
+			w.Header().Set("Content-Type", contentType(opt.ContentTypes, format))
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(status)
+			w.Write(rb)
+			return
+		}
+	}
+	http.Error(w, msg, status)
+}
+
+// requestFormat tells format of response to r, as far as known before the method resolves it, form
+// requests are responded in json.
+func (opt *Options) requestFormat(r *http.Request) string {
+	// Form is looked at only when already parsed, errors can come from parsing it.
+	q := r.Form
+	if q == nil {
+		q = r.URL.Query()
+	}
+	switch f := q.Get("format"); {
+	case f == "" && isGRPCWebRequest(r):
+		return "grpc-web"
+	case f == "" || f == "form":
+		return "json"
+	case opt.FormatFallback != nil && !isKnownFormat(f):
+		if fb := opt.FormatFallback(f); fb != "" {
+			return fb
+		}
+		return f
+	default:
+		return f
+	}
+}
+
+// setRetryAfter sets Retry-After header of 503 responses by RetryAfter, unless it's set already.
+func (opt *Options) setRetryAfter(w http.ResponseWriter, status int) {
+	if status != 503 || opt.RetryAfter <= 0 || w.Header().Get("Retry-After") != "" {
+		return
+	}
+	secs := int64((opt.RetryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+}
+
+// checkHTTPS responds 426 and returns false if RequireHTTPS is set and r came in plaintext.
+func (opt *Options) checkHTTPS(w http.ResponseWriter, r *http.Request) bool {
+	if !opt.RequireHTTPS || r.TLS != nil {
+		return true
+	}
+	if opt.TrustForwardedProto {
+		if p := r.Header.Values("X-Forwarded-Proto"); len(p) > 0 {
+			// Take the last one, set by proxy right in front of us.
+			parts := strings.Split(p[len(p)-1], ",")
+			if strings.EqualFold(strings.TrimSpace(parts[len(parts)-1]), "https") {
+				return true
+			}
+		}
+	}
+	w.Header().Set("Upgrade", "TLS/1.2, HTTP/1.1")
+	w.Header().Set("Connection", "Upgrade")
+	opt.httpError(w, r, 426, "HTTPS required")
+	return false
+}
+
+// defaultMaxQueryBytes is MaxQueryBytes when DecodeQuery is on.
+const defaultMaxQueryBytes = 8 << 10
+
+// checkQueryLen responds 414 and returns false if query of r exceeds MaxQueryBytes.
+func (opt *Options) checkQueryLen(w http.ResponseWriter, r *http.Request) bool {
+	n := opt.MaxQueryBytes
+	if n == 0 && opt.DecodeQuery {
+		n = defaultMaxQueryBytes
+	}
+	if n > 0 && len(r.URL.RawQuery) > n {
+		opt.httpError(w, r, 414, "Query too long")
+		return false
+	}
+	return true
+}
+
+// checkQueryParams responds 400 and returns false if StrictQuery is set and query of r has a
+// parameter swiffy doesn't use, nor in ReservedParams.
+func (opt *Options) checkQueryParams(w http.ResponseWriter, r *http.Request) bool {
+	if !opt.StrictQuery {
+		return true
+	}
+	allowed := map[string]bool{}
+	for _, name := range opt.reservedParams() {
+		allowed[name] = true
+	}
+	var unexpected []string
+	for name := range r.URL.Query() {
+		if !allowed[name] {
+			unexpected = append(unexpected, name)
+		}
+	}
+	if len(unexpected) > 0 {
+		sort.Strings(unexpected)
+		opt.httpError(w, r, 400, fmt.Sprintf("Unexpected query parameter %s", unexpected[0]))
+		return false
+	}
+	return true
+}
+
+// reservedParams returns query parameters that are never decoded into requests.
+func (opt *Options) reservedParams() []string {
+	return append(append([]string(nil), reservedParams...), opt.ReservedParams...)
+}
+
+// logRequestf logs about r, tagged with request ID when available.
+func (opt *Options) logRequestf(r *http.Request, format string, args ...interface{}) {
+	prefix := fmt.Sprintf("swiffy: %s %s", r.Method, r.URL.Path)
+	if id := RequestID(r.Context()); id != "" {
+		prefix += " [" + id + "]"
+	}
+	opt.logf("%s: %s", prefix, fmt.Sprintf(format, args...))
 }
 
 type methodHandler struct {
+	// Name the method is served as
+	name string
 	// The backend function to call
 	backend Handler
 	reqType reflect.Type
+	resType reflect.Type
+	// Whether backend takes a third argument produced by Options.DepsFunc
+	withDeps bool
+	// Whether backend takes a channel of requests streamed from NDJSON body
+	ingest bool
+	// Whether backend streams responses by a send function, see stream.go
+	stream bool
+	// Whether backend runs detached from request, see Options.AsyncMethods
+	async   bool
 	decoder RequestDecoder
 	encoder ResponseEncoder
+	opt     *Options
 }
 
-func newMethodHandler(fn interface{}, opt *Options) *methodHandler {
+func newMethodHandler(name string, fn interface{}, opt *Options) *methodHandler {
 	fnt := reflect.TypeOf(fn)
-	if fnt.Kind() != reflect.Func {
-		panic("fn is not a function")
+	if err := checkMethodShape(name, fnt, opt); err != nil {
+		panic(fmt.Sprintf("method %s: %v", name, err))
 	}
-	ctxType := reflect.TypeOf((*context.Context)(nil)).Elem()
-	errType := reflect.TypeOf((*error)(nil)).Elem()
+	// Bidirectional streaming methods both take requests like ingest and send responses like
+	// streaming ones.
+	bidi := isBidiShape(fnt)
+	ingest := fnt.In(1).Kind() == reflect.Chan || bidi
+	var reqType reflect.Type
 	switch {
-	case fnt.NumIn() != 2,
-		fnt.NumOut() != 2,
-		!fnt.In(0).Implements(ctxType),
-		// To allow create instance of input.
-		fnt.In(1).Kind() != reflect.Ptr,
-		fnt.Out(1) != errType:
-		panic("fn should be like func(context.Context, *requestProto) (*responesProto, error)")
+	case bidi:
+		reqType = fnt.In(1).Out(0).Elem()
+	case ingest:
+		reqType = fnt.In(1).Elem().Elem()
+	default:
+		reqType = fnt.In(1).Elem()
+	}
+	stream := isStreamShape(fnt) || bidi
+	resType := fnt.Out(0)
+	if stream {
+		resType = fnt.In(2).In(0)
 	}
+	withDeps := fnt.NumIn() == 3 && !stream
 	fnv := reflect.ValueOf(fn)
 	bh := func(ctx context.Context, req interface{}) (interface{}, error) {
-		ret := fnv.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(req)})
+		args := []reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(req)}
+		// Both are missing when a middleware passes on a context not derived from the one it got.
+		if stream {
+			s, ok := ctx.Value(streamKey{}).(*responseStream)
+			if !ok {
+				opt.logf("swiffy: %s called without response stream in context", name)
+				return nil, Error(500, "", nil)
+			}
+			args = append(args, s.sendFunc(fnt.In(2)))
+			err, _ := fnv.Call(args)[0].Interface().(error)
+			return nil, err
+		}
+		if withDeps {
+			deps, ok := ctx.Value(depsKey{}).(reflect.Value)
+			if !ok {
+				opt.logf("swiffy: %s called without deps in context", name)
+				return nil, Error(500, "", nil)
+			}
+			args = append(args, deps)
+		}
+		ret := fnv.Call(args)
 		res := ret[0].Interface()
 		err, _ := ret[1].Interface().(error)
 		return res, err
 	}
+	async := opt.AsyncMethods[name]
+	if mw, ok := opt.MethodMiddleware[name]; ok {
+		bh = mw(bh)
+	}
 	if opt.Middleware != nil {
 		bh = opt.Middleware(bh)
 	}
 	return &methodHandler{
-		backend: bh,
-		reqType: fnt.In(1).Elem(),
-		decoder: opt.RequestDecoder,
-		encoder: opt.ResponseEncoder,
+		name:     name,
+		backend:  bh,
+		reqType:  reqType,
+		resType:  resType,
+		withDeps: withDeps,
+		ingest:   ingest,
+		stream:   stream,
+		async:    async,
+		decoder:  opt.RequestDecoder,
+		encoder:  opt.ResponseEncoder,
+		opt:      opt,
 	}
 }
 
+// checkMethodShape returns error if fnt is not a function swiffy can serve as method name
+// with opt.
+func checkMethodShape(name string, fnt reflect.Type, opt *Options) error {
+	if fnt.Kind() != reflect.Func {
+		return fmt.Errorf("fn is %v, not a function", fnt)
+	}
+	var req, res reflect.Type
+	stream := isStreamShape(fnt) || isBidiShape(fnt)
+	switch {
+	case isBidiShape(fnt):
+		req, res = fnt.In(1).Out(0), fnt.In(2).In(0)
+	case stream:
+		req, res = fnt.In(1), fnt.In(2).In(0)
+	case fnt.NumIn() != 2 && fnt.NumIn() != 3,
+		fnt.NumOut() != 2,
+		!fnt.In(0).Implements(ctxType),
+		// To allow create instance of input.
+		fnt.In(1).Kind() != reflect.Ptr && !isIngestType(fnt.In(1)),
+		fnt.Out(1) != errType:
+		return fmt.Errorf("fn should be like func(context.Context, *requestProto) (*responesProto, error), got %v taking %d and returning %d values",
+			fnt, fnt.NumIn(), fnt.NumOut())
+	case isIngestType(fnt.In(1)):
+		req, res = fnt.In(1).Elem(), fnt.Out(0)
+	default:
+		req, res = fnt.In(1), fnt.Out(0)
+	}
+	if !req.Implements(protoType) {
+		return fmt.Errorf("fn takes %v, not a proto", req)
+	}
+	if !res.Implements(protoType) {
+		return fmt.Errorf("fn returns %v, not a proto", res)
+	}
+	if fnt.NumIn() == 3 && !stream {
+		if opt.DepsFunc == nil {
+			return fmt.Errorf("fn %v takes a third argument but Options.DepsFunc is nil", fnt)
+		}
+		if dt := reflect.TypeOf(opt.DepsFunc).Out(0); !dt.AssignableTo(fnt.In(2)) {
+			return fmt.Errorf("fn takes %v as third argument but Options.DepsFunc produces %v", fnt.In(2), dt)
+		}
+	}
+	if opt.AsyncMethods[name] && (stream || isIngestType(fnt.In(1))) {
+		return fmt.Errorf("streaming method can't be async")
+	}
+	return nil
+}
+
+var (
+	ctxType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errType = reflect.TypeOf((*error)(nil)).Elem()
+	// proto.Message of golang/protobuf, which generated messages of both APIs implement
+	protoType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+	reqType   = reflect.TypeOf((*http.Request)(nil))
+)
+
+type depsKey struct{}
+
+// baseContext takes deadline and cancellation from request context, and looks up values from
+// request context first then base.
+type baseContext struct {
+	context.Context
+	base context.Context
+}
+
+func (c *baseContext) Value(key interface{}) interface{} {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	return c.base.Value(key)
+}
+
+// valuesContext looks up values from its parent context first then values.
+type valuesContext struct {
+	context.Context
+	values map[interface{}]interface{}
+}
+
+func (c *valuesContext) Value(key interface{}) interface{} {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	if key == nil || !reflect.TypeOf(key).Comparable() {
+		return nil
+	}
+	return c.values[key]
+}
+
+// checkDepsFunc panics if fn is not like func(context.Context, *http.Request) (Deps, error).
+func checkDepsFunc(fn interface{}) {
+	fnt := reflect.TypeOf(fn)
+	if fnt.Kind() != reflect.Func ||
+		fnt.NumIn() != 2 || fnt.NumOut() != 2 ||
+		fnt.In(0) != ctxType || fnt.In(1) != reqType || fnt.Out(1) != errType {
+		panic("DepsFunc should be like func(context.Context, *http.Request) (Deps, error)")
+	}
+}
+
+// makeDeps calls DepsFunc and returns its result.
+func (h *methodHandler) makeDeps(ctx context.Context, r *http.Request) (reflect.Value, error) {
+	ret := reflect.ValueOf(h.opt.DepsFunc).Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(r)})
+	err, _ := ret[1].Interface().(error)
+	return ret[0], err
+}
+
 func (h *methodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Body of ingest methods is read as they go, MaxRequestBytes caps each line instead.
+	if !h.ingest {
+		h.opt.limitBody(w, r)
+	}
+	// Compression buffers whole response, which defeats streaming.
+	if c := h.opt.Compression; c != nil && !h.stream {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if coding := c.negotiate(r); coding != "" {
+			cw := &compressWriter{ResponseWriter: w, c: c, coding: coding}
+			defer func() {
+				if err := cw.finish(); err != nil {
+					h.opt.logRequestf(r, "Write compressed response failed, %v", err)
+				}
+			}()
+			w = cw
+		}
+	}
+	obs := &Observation{Method: h.name}
+	if h.opt.Observer == nil && h.opt.SlowThreshold <= 0 && h.opt.recent == nil {
+		h.serve(w, r, obs)
+		return
+	}
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w}
+	h.serve(sw, r, obs)
+	obs.Status = sw.status
+	obs.ResponseBytes = sw.n
+	obs.Duration = time.Since(start)
+	if d := h.opt.SlowThreshold; d > 0 && obs.Duration > d {
+		h.opt.logRequestf(r, "Slow request of %s, took %v, status %d", h.name, obs.Duration, obs.Status)
+	}
+	if h.opt.recent != nil {
+		h.opt.recent.add(start, obs)
+	}
+	if h.opt.Observer != nil {
+		h.opt.Observer(r, obs)
+	}
+}
+
+// serve serves a method request, filling obs along the way.
+func (h *methodHandler) serve(w http.ResponseWriter, r *http.Request, obs *Observation) {
+	start := time.Now()
 	var err error
+	// Skipped when the endpoint has called it already.
+	if h.opt.PreFilter != nil && r.Context().Value(preFilteredKey{}) != h.opt && !h.opt.PreFilter(w, r) {
+		return
+	}
+	if !h.opt.checkHTTPS(w, r) || !h.opt.checkQueryLen(w, r) || !h.opt.checkQueryParams(w, r) ||
+		!h.opt.parseForm(w, r) {
+		return
+	}
+	if h.opt.Playground && r.Method == "GET" && r.URL.Query().Get("playground") != "" {
+		h.servePlayground(w, r)
+		return
+	}
 	format := r.FormValue("format")
+	if name := duplicateParam(r, "format"); name != "" {
+		h.opt.httpError(w, r, 400, fmt.Sprintf("Duplicate %s parameter", name))
+		return
+	}
+	obs.RequestedFormat = format
 	if format == "" {
-		format = "json"
+		if isGRPCWebRequest(r) {
+			format = "grpc-web"
+		} else {
+			format = "json"
+		}
+	} else if h.opt.FormatFallback != nil && !isKnownFormat(format) {
+		if f := h.opt.FormatFallback(format); f != "" {
+			format = f
+		}
 	}
+	obs.Format = format
 	var rb []byte
 	if s := r.FormValue("request"); s != "" {
+		if h.opt.DisableRequestParam {
+			h.opt.httpError(w, r, 400, "request parameter is not allowed, send request in body")
+			return
+		}
 		rb = ([]byte)(s)
-	} else {
-		rb, err = ioutil.ReadAll(r.Body)
+		if r.Method == "GET" && h.opt.LinkMethods[h.name] {
+			if rb, err = decodeBase64URL(s); err != nil {
+				h.opt.httpError(w, r, 400, fmt.Sprintf("Decode base64url request failed, %v", err))
+				return
+			}
+		} else if r.PostForm.Get("request") != "" && isTextFormat(format) {
+			// From a form post, in charset of the form.
+			if rb, err = toUTF8(r.Header.Get("Content-Type"), rb); err != nil {
+				h.opt.httpError(w, r, err.(WithHTTPStatus).HTTPStatus(), err.Error())
+				return
+			}
+		}
+		if n := h.opt.MaxRequestBytes; n > 0 && len(rb) > n {
+			h.opt.httpError(w, r, 413, "Request too large")
+			return
+		}
+	} else if format == "form" {
+		// Body is decoded as JSON converted from form, response is in json.
+		if rb, err = h.formToJSON(r); err != nil {
+			h.opt.httpError(w, r, err.(WithHTTPStatus).HTTPStatus(), err.Error())
+			return
+		}
+		format = "json"
+	} else if h.opt.MultipartMixed && format == "json" && isMultipartMixed(r) {
+		if rb, err = h.multipartToJSON(w, r); err != nil {
+			st := 400
+			if e, ok := err.(WithHTTPStatus); ok {
+				st = e.HTTPStatus()
+			}
+			h.opt.httpError(w, r, st, err.Error())
+			return
+		}
+	} else if !h.ingest {
+		if isFormBody(r) && len(r.PostForm) > 0 {
+			// Its body is already parsed as form, so can't be taken as request.
+			h.opt.httpError(w, r, 400, "Form body needs request field, or format form")
+			return
+		}
+		if err := h.opt.checkContentType(r, format); err != nil {
+			h.opt.httpError(w, r, 415, err.Error())
+			return
+		}
+		rb, err = h.opt.readBody(w, r)
+		if err == nil && isTextFormat(format) {
+			rb, err = toUTF8(r.Header.Get("Content-Type"), rb)
+		}
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Read request from HTTP body failed, %v", err), 400)
+			h.opt.httpError(w, r, err.(WithHTTPStatus).HTTPStatus(), err.Error())
 			return
 		}
 	}
 
 	ctx := r.Context()
+	if len(h.opt.ContextValues) > 0 {
+		ctx = &valuesContext{Context: ctx, values: h.opt.ContextValues}
+	}
+	if h.opt.BaseContext != nil {
+		ctx = &baseContext{Context: ctx, base: h.opt.BaseContext}
+	}
+	ctx = context.WithValue(ctx, methodNameKey{}, h.name)
+	if h.opt.ServerTiming {
+		ctx = withServerTimings(ctx)
+	}
+	r = r.WithContext(ctx)
+	if s := r.FormValue("wait"); s != "" && h.opt.MaxWait > 0 {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			h.opt.httpError(w, r, 400, fmt.Sprintf("Invalid wait %q", s))
+			return
+		}
+		if d > h.opt.MaxWait {
+			d = h.opt.MaxWait
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	if name := h.opt.TimeoutHeader; name != "" {
+		if s := r.Header.Get(name); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				h.opt.httpError(w, r, 400, fmt.Sprintf("Invalid %s %q", name, s))
+				return
+			}
+			if h.opt.MaxTimeout > 0 && d > h.opt.MaxTimeout {
+				d = h.opt.MaxTimeout
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+	}
+	if h.withDeps {
+		deps, err := h.makeDeps(ctx, r)
+		if err != nil {
+			h.writeError(w, r, err, format)
+			return
+		}
+		ctx = context.WithValue(ctx, depsKey{}, deps)
+		r = r.WithContext(ctx)
+	}
+	if h.ingest && h.stream {
+		h.serveBidi(w, r, obs, rb, format)
+		return
+	}
+	if h.ingest {
+		h.serveIngest(w, r, obs, rb, format)
+		return
+	}
+	if h.opt.RequestPath != "" && format == "json" && len(rb) > 0 {
+		if rb, err = extractJSONPath(rb, h.opt.RequestPath); err != nil {
+			h.opt.httpError(w, r, 400, err.Error())
+			return
+		}
+	}
+	if h.opt.AllowBulk && format == "json" && isJSONArray(rb) {
+		if h.stream || h.async {
+			// Their responses, a stream or 202, can't be aggregated per element.
+			h.opt.httpError(w, r, 400, "Bulk request is not supported by method")
+			return
+		}
+		h.audit(r, obs, format, rb)
+		h.serveBulk(w, r, rb)
+		return
+	}
 	req := reflect.New(h.reqType).Interface()
-	if err := h.decoder(req, rb, format); err != nil {
-		http.Error(w, fmt.Sprintf("Decode request failed, %v", err), 400)
+	decodeFormat := format
+	if format == "csv" {
+		// CSV is for responses only, requests are in json.
+		decodeFormat = "json"
+	}
+	if h.opt.DecodeQuery && len(rb) == 0 {
+		if m, ok := req.(proto.Message); ok {
+			if rb, err = queryToJSON(proto.MessageReflect(m).Descriptor(), r.URL.Query(), h.opt.reservedParams()); err != nil {
+				h.opt.httpError(w, r, 400, err.Error())
+				return
+			}
+			decodeFormat = "json"
+		}
+	}
+	h.audit(r, obs, decodeFormat, rb)
+	if err := h.decode(ctx, req, rb, decodeFormat); err != nil {
+		h.opt.httpError(w, r, 400, fmt.Sprintf("Decode request failed, %v", err))
 		return
 	}
-	res, err := h.backend(ctx, req)
+	if err := h.transform(req); err != nil {
+		h.opt.httpError(w, r, 400, err.Error())
+		return
+	}
+	if h.opt.DeprecationWarnings {
+		addDeprecationWarnings(w, req)
+	}
+	if h.stream {
+		h.serveStream(w, r, req, format)
+		return
+	}
+	if h.async {
+		h.callAsync(ctx, r, req)
+		w.WriteHeader(202)
+		return
+	}
+	timing := h.opt.DebugTiming && r.FormValue("debug") == "timing"
+	if timing {
+		setTimingHeader(w, "X-Decode-Ms", time.Since(start))
+	}
+	called := time.Now()
+	res, err := h.call(ctx, req)
+	if timing {
+		setTimingHeader(w, "X-Backend-Ms", time.Since(called))
+	}
+	setServerTiming(w, ctx)
+	partial := err != nil && h.opt.PartialOnDeadline[h.name] && ctx.Err() == context.DeadlineExceeded && !isNil(res)
 
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	if err != nil {
-		st := 500
-		if e, ok := err.(WithHTTPStatus); ok {
-			st = e.HTTPStatus()
+	if partial {
+		w.Header().Set("X-Partial", "true")
+	} else if err != nil {
+		h.writeError(w, r, err, format)
+		return
+	}
+	status := 200
+	if p, ok := res.(Partial); ok && p.IsPartial() {
+		w.Header().Set("X-Has-More", "true")
+		if h.opt.PartialStatus != 0 {
+			status = h.opt.PartialStatus
+		}
+	}
+	if h.opt.StatusField != "" {
+		if st := statusFromField(res, h.opt.StatusField); st != 0 {
+			if st < 200 || st > 599 {
+				h.opt.logRequestf(r, "Invalid status %d in response field %s", st, h.opt.StatusField)
+				h.opt.httpError(w, r, 500, http.StatusText(500))
+				return
+			}
+			status = st
+		}
+	}
+	// StatusField may have made it an error.
+	if status < 400 {
+		for _, link := range h.opt.PreloadLinks[h.name] {
+			if !strings.HasPrefix(link, "<") {
+				link = "<" + link + ">; rel=preload"
+			}
+			w.Header().Add("Link", link)
+		}
+	}
+	if h.opt.NoContentOnEmpty && format != "grpc-web" && isEmptyProto(res) {
+		mergeHeaders(w, res)
+		w.WriteHeader(204)
+		return
+	}
+	// Statuses from StatusField that can't have a body.
+	if status == 204 || status == 304 {
+		mergeHeaders(w, res)
+		w.WriteHeader(status)
+		return
+	}
+	if timing {
+		// Buffer response so that its encoding time can go in a header.
+		encoded := time.Now()
+		buf := newResponseBuffer()
+		if err := h.encode(buf, r, status, res, format); err != nil {
+			h.opt.httpError(w, r, 500, fmt.Sprintf("Encode response failed, %v", err))
+			return
+		}
+		setTimingHeader(w, "X-Encode-Ms", time.Since(encoded))
+		buf.flush(w)
+		return
+	}
+	if err := h.encode(w, r, status, res, format); err != nil {
+		h.opt.httpError(w, r, 500, fmt.Sprintf("Encode response failed, %v", err))
+		return
+	}
+}
+
+// audit passes request bytes about to be decoded to AuditBody of the method if any, and notes
+// them in obs.
+func (h *methodHandler) audit(r *http.Request, obs *Observation, format string, body []byte) {
+	obs.RequestBytes = int64(len(body))
+	if fn := h.opt.AuditBody[h.name]; fn != nil {
+		fn(r, format, body)
+	}
+	if h.opt.recent != nil && format == "json" {
+		obs.body = body
+	}
+}
+
+// isKnownFormat tells whether format is one swiffy serves.
+func isKnownFormat(format string) bool {
+	switch format {
+	case "json", "proto", "text", "grpc-web", "csv", "yaml", "form":
+		return true
+	default:
+		return false
+	}
+}
+
+// isTextFormat tells whether requests of format are text, subject to charset conversion.
+func isTextFormat(format string) bool {
+	switch format {
+	case "json", "text", "csv", "yaml":
+		return true
+	default:
+		return false
+	}
+}
+
+// setTimingHeader sets header name to d in milliseconds.
+func setTimingHeader(w http.ResponseWriter, name string, d time.Duration) {
+	w.Header().Set(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64))
+}
+
+// statusFromField returns value of integer or enum field named name, by proto or JSON name, of
+// res, or 0 if there's no such field.
+func statusFromField(res interface{}, name string) int {
+	m, ok := res.(proto.Message)
+	if !ok {
+		return 0
+	}
+	rm := proto.MessageReflect(m)
+	fields := rm.Descriptor().Fields()
+	fd := fields.ByName(protoreflect.Name(name))
+	if fd == nil {
+		fd = fields.ByJSONName(name)
+	}
+	if fd == nil || fd.IsList() || fd.IsMap() {
+		return 0
+	}
+	v := rm.Get(fd)
+	switch fd.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return int(v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return int(v.Uint())
+	case protoreflect.EnumKind:
+		return int(v.Enum())
+	}
+	return 0
+}
+
+// isEmptyProto tells whether v is a proto message with no field set, or a nil one.
+func isEmptyProto(v interface{}) bool {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return false
+	}
+	if rv := reflect.ValueOf(m); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return true
+	}
+	return proto.Size(m) == 0
+}
+
+// decode decodes src into dst by RequestDecoder, the default one also gets ctx.
+func (h *methodHandler) decode(ctx context.Context, dst interface{}, src []byte, format string) error {
+	if h.opt.codec != nil {
+		return h.opt.codec.decodeContext(ctx, dst, src, format)
+	}
+	return h.decoder(dst, src, format)
+}
+
+// transform checks field mask of decoded req with ValidateFieldMask, then applies
+// RequestTransforms to it in order. Every path decoding requests goes through it.
+func (h *methodHandler) transform(req interface{}) error {
+	if h.opt.ValidateFieldMask {
+		if err := validateFieldMask(req); err != nil {
+			return err
+		}
+	}
+	for _, t := range h.opt.RequestTransforms {
+		if t == nil {
+			continue
+		}
+		if err := t(req); err != nil {
+			return fmt.Errorf("Transform request failed, %v", err)
 		}
-		if e, ok := err.(WithMessage); ok {
-			if m := e.Message(); m != nil && h.encoder(w, st, m, format) == nil {
+	}
+	return nil
+}
+
+// call invokes backend, with Retry for idempotent methods. With RecoverPanics, a panic is
+// turned into a 500 error.
+func (h *methodHandler) call(ctx context.Context, req interface{}) (res interface{}, err error) {
+	if h.opt.RecoverPanics {
+		defer func() {
+			p := recover()
+			if p == nil {
 				return
 			}
-			// When we cannot encode message provided, we fallback to use err.String()
-			// This might not be the best strategy because client may blindly trying to
-			// parse the pure text and blow up. But we should blame client for blow up
-			// handling plain text HTTP error message then.
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			stack := debug.Stack()
+			if h.opt.OnPanic != nil {
+				h.opt.OnPanic(ctx, MethodName(ctx), p, stack)
+			} else {
+				h.opt.logf("swiffy: panic serving %s: %v\n%s", MethodName(ctx), p, stack)
+			}
+			res, err = nil, Error(500, "", nil)
+		}()
+	}
+	// Requests and responses of streaming methods are consumed as they go, they can't be replayed.
+	if h.opt.Retry != nil && h.opt.IdempotentMethods[h.name] && !h.stream && !h.ingest {
+		res, err = h.opt.Retry.call(ctx, h.backend, req)
+	} else {
+		res, err = h.backend(ctx, req)
+	}
+	if err != nil && h.opt.ErrorMapper != nil {
+		if mapped := h.opt.ErrorMapper(err); mapped != nil {
+			err = mapped
+		}
+	}
+	if err == nil && isNil(res) {
+		// A nil response, typed or not, is served as an empty one, encoders can't take nil.
+		switch {
+		case res != nil && reflect.TypeOf(res).Kind() == reflect.Ptr:
+			res = reflect.New(reflect.TypeOf(res).Elem()).Interface()
+		case h.resType.Kind() == reflect.Ptr:
+			res = reflect.New(h.resType.Elem()).Interface()
+		default:
+			// Untyped nil from a method returning an interface, its type is unknown.
+			res = &emptypb.Empty{}
+		}
+	}
+	return res, err
+}
+
+// isNil tells whether v is nil or holds a nil pointer, map, slice and the like.
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan:
+		return rv.IsNil()
+	default:
+		return false
+	}
+}
+
+// DefaultContentTypes lists usual request Content-Types of formats, it can be used as
+// Options.AllowedContentTypes.
+var DefaultContentTypes = map[string][]string{
+	"json":     {"application/json", "text/json"},
+	"proto":    {"application/x-protobuf", "application/octet-stream"},
+	"text":     {"text/plain"},
+	"grpc-web": {grpcWebContentType, grpcWebContentType + "+proto"},
+	"ndjson":   {"application/x-ndjson", "application/jsonl"},
+	"yaml":     {"application/yaml", "application/x-yaml", "text/yaml"},
+}
+
+// checkContentType checks Content-Type of a request with body against AllowedContentTypes.
+func (opt *Options) checkContentType(r *http.Request, format string) error {
+	allowed, ok := opt.AllowedContentTypes[format]
+	if !ok || r.ContentLength == 0 {
+		return nil
+	}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	for _, t := range allowed {
+		if strings.EqualFold(mt, t) {
+			return nil
 		}
-		fmt.Fprintln(w, err)
+	}
+	return fmt.Errorf("Content-Type %q not allowed for format %s", mt, format)
+}
+
+// readBody reads request body, decompressing it according to Content-Encoding. Returned error
+// carries the HTTP status to reply.
+func (opt *Options) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	defer opt.setReadDeadline(w)()
+	body, closeBody, err := openBody(r)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody()
+	n := opt.MaxRequestBytes
+	if n > 0 {
+		// Limit applies to the decompressed size, read one more byte to detect overflow.
+		body = io.LimitReader(body, int64(n)+1)
+	}
+	rb, err := ioutil.ReadAll(body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, Error(413, "Request too large", nil)
+	}
+	if isTimeout(err) {
+		// Or server would try reading rest of body before replying, with deadline cleared.
+		w.Header().Set("Connection", "close")
+		return nil, Error(408, "Read request from HTTP body timed out", nil)
+	}
+	if err != nil {
+		return nil, Error(400, fmt.Sprintf("Read request from HTTP body failed, %v", err), nil)
+	}
+	if n > 0 && len(rb) > n {
+		return nil, Error(413, "Request too large", nil)
+	}
+	return rb, nil
+}
+
+// setReadDeadline sets read deadline of ReadTimeout from now when positive, returning func to
+// clear it once body is read.
+func (opt *Options) setReadDeadline(w http.ResponseWriter) func() {
+	if opt.ReadTimeout <= 0 {
+		return func() {}
+	}
+	rc := http.NewResponseController(w)
+	if rc.SetReadDeadline(time.Now().Add(opt.ReadTimeout)) != nil {
+		return func() {}
+	}
+	return func() { rc.SetReadDeadline(time.Time{}) }
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// limitBody caps r.Body at MaxRequestBytes before anything reads it, form parsing included, which
+// happens ahead of readBody. It caps compressed size of compressed bodies, readBody still checks
+// the decompressed one.
+func (opt *Options) limitBody(w http.ResponseWriter, r *http.Request) {
+	if n := opt.MaxRequestBytes; n > 0 && r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(n))
+	}
+}
+
+// parseForm parses query and form body of r up front, because FormValue drops parse errors and
+// would go on as if a form body beyond MaxRequestBytes or ReadTimeout had no fields. It replies
+// the error and returns false in that case.
+func (opt *Options) parseForm(w http.ResponseWriter, r *http.Request) bool {
+	defer opt.setReadDeadline(w)()
+	err := r.ParseForm()
+	if err == nil {
+		// 32MB in memory like FormValue.
+		err = r.ParseMultipartForm(32 << 20)
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		opt.httpError(w, r, 413, "Request too large")
+		return false
+	}
+	if isTimeout(err) {
+		w.Header().Set("Connection", "close")
+		opt.httpError(w, r, 408, "Read request from HTTP body timed out")
+		return false
+	}
+	return true
+}
+
+// openBody returns reader of request body decompressed according to Content-Encoding, the
+// returned func releases resources held by the reader.
+func openBody(r *http.Request) (io.Reader, func(), error) {
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return r.Body, func() {}, nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, nil, Error(400, fmt.Sprintf("Read gzip request from HTTP body failed, %v", err), nil)
+		}
+		return zr, func() { zr.Close() }, nil
+	default:
+		return nil, nil, Error(415, fmt.Sprintf("Unsupported Content-Encoding %s", enc), nil)
+	}
+}
+
+// writeError reports err returned by backend to client.
+func (h *methodHandler) writeError(w http.ResponseWriter, r *http.Request, err error, format string) {
+	st, text := h.errorStatus(r, err)
+	mergeHeaders(w, err)
+	h.opt.setRetryAfter(w, st)
+	if format == "grpc-web" {
+		writeGRPCWebError(w, contentType(h.opt.ContentTypes, format), st, text)
 		return
 	}
-	if err := h.encoder(w, 200, res, format); err != nil {
-		http.Error(w, fmt.Sprintf("Encode response failed, %v", err), 500)
+	if h.opt.setErrorHeaders(w, st, text) {
+		w.WriteHeader(st)
 		return
 	}
+	if e, ok := err.(WithMessage); ok {
+		if m := e.Message(); m != nil && h.encode(w, r, st, m, format) == nil {
+			return
+		}
+		// When we cannot encode message provided, we fallback to use err.String()
+		// This might not be the best strategy because client may blindly trying to
+		// parse the pure text and blow up. But we should blame client for blow up
+		// handling plain text HTTP error message then.
+	}
+	h.opt.replyError(w, r, st, text, format)
+}
+
+// encode writes src to w by encoder. The output is buffered when response size is capped, so
+// that an oversized response can still be turned into an error, or when it's to be wrapped by
+// Envelope.
+func (h *methodHandler) encode(w http.ResponseWriter, r *http.Request, status int, src interface{}, format string) error {
+	// Headers must go out before encoder calls WriteHeader.
+	mergeHeaders(w, src)
+	wrap := h.opt.Envelope != nil && format == "json"
+	if h.opt.MaxResponseBytes <= 0 && !wrap && h.opt.ResponseChecksum == NoChecksum {
+		return h.encoder(w, status, src, format)
+	}
+	buf := newResponseBuffer()
+	buf.limit = h.opt.MaxResponseBytes
+	if err := h.encoder(buf, status, src, format); err != nil {
+		if _, ok := err.(errResponseTooLarge); ok {
+			h.opt.logRequestf(r, "%v", err)
+		}
+		return err
+	}
+	if wrap {
+		rb, err := h.opt.Envelope.wrap(r, envelopeKey(status), buf.body.Bytes())
+		if err != nil {
+			return err
+		}
+		buf.body.Reset()
+		buf.body.Write(rb)
+	}
+	setChecksum(buf, h.opt.ResponseChecksum, buf.body.Bytes())
+	return buf.flush(w)
+}
+
+// errorStatus resolves HTTP status and the text reported to client for err returned by backend.
+func (h *methodHandler) errorStatus(r *http.Request, err error) (int, string) {
+	st := 500
+	if e, ok := err.(WithHTTPStatus); ok {
+		st = e.HTTPStatus()
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		st = 504
+	}
+	text := err.Error()
+	if h.opt.SanitizeErrors && !isReportable(err) {
+		h.opt.logRequestf(r, "%v", err)
+		text = http.StatusText(st)
+	}
+	return st, text
 }
 
 // ProtoDecoder implements RequestDecoder for protobuf.
 func ProtoDecoder(dst interface{}, src []byte, format string) error {
+	return (&protoCodec{}).decode(dst, src, format)
+}
+
+// ProtoEncoder implements ResponseEncoder for protobuf.
+func ProtoEncoder(w http.ResponseWriter, status int, src interface{}, format string) error {
+	return (&protoCodec{}).encode(w, status, src, format)
+}
+
+// protoCodec implements ProtoDecoder and ProtoEncoder, NewServiceHandler configures it from
+// Options when no custom decoder / encoder is given.
+type protoCodec struct {
+	int64AsNumber bool
+	enumsAsInts   bool
+	canonicalJSON bool
+	prototext     bool
+	contentTypes  map[string]string
+	lengthPrefix  bool
+	flattenAny    map[string]bool
+	nonFinite     NonFiniteMode
+	csvField      string
+	grpcFramed    bool
+}
+
+func newProtoCodec(opt *Options) *protoCodec {
+	return &protoCodec{
+		int64AsNumber: opt.Int64AsNumber,
+		enumsAsInts:   opt.EnumsAsInts,
+		canonicalJSON: opt.CanonicalJSON,
+		prototext:     opt.Prototext,
+		contentTypes:  opt.ContentTypes,
+		lengthPrefix:  opt.LengthPrefixProto,
+		flattenAny:    opt.FlattenAnyTypes,
+		nonFinite:     opt.NonFiniteFloats,
+		csvField:      opt.CSVField,
+		grpcFramed:    opt.GRPCFramedProto,
+	}
+}
+
+// defaultContentTypes are Content-Types of responses by format.
+var defaultContentTypes = map[string]string{
+	"json":     "text/json; charset=utf-8",
+	"proto":    "application/x-protobuf",
+	"text":     "text/plain; charset=utf-8",
+	"grpc-web": grpcWebContentType + "+proto",
+	"csv":      "text/csv; charset=utf-8",
+	"yaml":     "application/yaml",
+}
+
+// contentType returns Content-Type of responses in format, with overrides in types.
+func contentType(types map[string]string, format string) string {
+	if t, ok := types[format]; ok {
+		return t
+	}
+	return defaultContentTypes[format]
+}
+
+// decode covers json, yaml, proto, text and grpc-web formats, form and query requests are
+// converted to json first. Input comes from untrusted clients, so it must fail with an error
+// rather than panic whatever src is, FuzzProtoDecoder checks that.
+func (c *protoCodec) decode(dst interface{}, src []byte, format string) error {
+	return c.decodeContext(context.Background(), dst, src, format)
+}
+
+// decodeContext is decode, with json Any types resolved by resolver in ctx if any.
+func (c *protoCodec) decodeContext(ctx context.Context, dst interface{}, src []byte, format string) (err error) {
 	if len(src) == 0 {
 		return nil
 	}
@@ -184,43 +1586,123 @@ func ProtoDecoder(dst interface{}, src []byte, format string) error {
 	if !ok {
 		return fmt.Errorf("Decode destination is not proto")
 	}
+	if v := reflect.ValueOf(dstProto); v.Kind() == reflect.Ptr && v.IsNil() {
+		return fmt.Errorf("Decode destination is nil")
+	}
 	switch format {
+	case "yaml":
+		if src, err = yamlToJSON(src); err != nil {
+			return err
+		}
+		return c.decodeContext(ctx, dst, src, "json")
 	case "json":
+		if resolver := anyResolver(ctx); resolver != nil {
+			return (&jsonpb.Unmarshaler{AnyResolver: resolver}).Unmarshal(bytes.NewBuffer(src), dstProto)
+		}
 		return jsonpb.Unmarshal(bytes.NewBuffer(src), dstProto)
 	case "proto":
+		if c.grpcFramed {
+			if src, err = readGRPCFrame(src); err != nil {
+				return err
+			}
+		}
 		return proto.Unmarshal(src, dstProto)
 	case "text":
-		return proto.UnmarshalText(string(src), dstProto)
+		if c.prototext {
+			return prototext.Unmarshal(src, proto.MessageV2(dstProto))
+		}
+		if err := proto.UnmarshalText(string(src), dstProto); err != nil {
+			return err
+		}
+		// Legacy text parser lets escapes put invalid UTF-8 in proto3 strings, which fail to
+		// encode later.
+		_, err := proto.Marshal(dstProto)
+		return err
+	case "grpc-web":
+		payload, err := readGRPCWebFrame(src)
+		if err != nil {
+			return err
+		}
+		return proto.Unmarshal(payload, dstProto)
 	default:
 		return fmt.Errorf("Unknown format %s", format)
 	}
 }
 
-// ProtoEncoder implements ResponseEncoder for protobuf.
-func ProtoEncoder(w http.ResponseWriter, status int, src interface{}, format string) error {
+func (c *protoCodec) encode(w http.ResponseWriter, status int, src interface{}, format string) error {
 	srcProto, ok := src.(proto.Message)
 	if !ok {
 		return fmt.Errorf("Encode source is not proto")
 	}
 	switch format {
 	case "json":
-		w.Header().Add("Content-Type", "text/json; charset=utf-8")
+		rb, err := c.marshalJSON(srcProto)
+		if err != nil {
+			return err
+		}
+		w.Header().Add("Content-Type", contentType(c.contentTypes, format))
 		w.WriteHeader(status)
-		m := jsonpb.Marshaler{}
-		return m.Marshal(w, srcProto)
+		_, err = w.Write(rb)
+		return err
 	case "proto":
-		w.Header().Add("Content-Type", "application/x-protobuf")
+		w.Header().Add("Content-Type", contentType(c.contentTypes, format))
 		w.WriteHeader(status)
 		rb, err := proto.Marshal(srcProto)
 		if err != nil {
 			return err
 		}
+		if c.lengthPrefix {
+			rb = append(proto.EncodeVarint(uint64(len(rb))), rb...)
+		}
 		_, err = w.Write(rb)
 		return err
 	case "text":
-		w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+		if c.prototext {
+			rb, err := prototext.MarshalOptions{Multiline: true}.Marshal(proto.MessageV2(srcProto))
+			if err != nil {
+				return err
+			}
+			w.Header().Add("Content-Type", contentType(c.contentTypes, format))
+			w.WriteHeader(status)
+			_, err = w.Write(rb)
+			return err
+		}
+		w.Header().Add("Content-Type", contentType(c.contentTypes, format))
 		w.WriteHeader(status)
 		return proto.MarshalText(w, srcProto)
+	case "yaml":
+		rb, err := c.marshalJSON(srcProto)
+		if err == nil {
+			rb, err = jsonToYAML(rb)
+		}
+		if err != nil {
+			return err
+		}
+		w.Header().Add("Content-Type", contentType(c.contentTypes, format))
+		w.WriteHeader(status)
+		_, err = w.Write(rb)
+		return err
+	case "csv":
+		rb, err := c.marshalCSV(srcProto)
+		if err != nil {
+			return err
+		}
+		w.Header().Add("Content-Type", contentType(c.contentTypes, format))
+		w.WriteHeader(status)
+		_, err = w.Write(rb)
+		return err
+	case "grpc-web":
+		rb, err := proto.Marshal(srcProto)
+		if err != nil {
+			return err
+		}
+		// gRPC-Web reports status in trailer, HTTP status is always 200.
+		w.Header().Add("Content-Type", contentType(c.contentTypes, format))
+		w.WriteHeader(200)
+		if err := writeGRPCWebFrame(w, 0, rb); err != nil {
+			return err
+		}
+		return writeGRPCWebTrailer(w, grpcCodeFromHTTP(status), "")
 	default:
 		return fmt.Errorf("Unknown format %s", format)
 	}
@@ -228,6 +1710,9 @@ func ProtoEncoder(w http.ResponseWriter, status int, src interface{}, format str
 
 type serviceHandler struct {
 	methods map[string]http.Handler
+	// Method names keyed by their snake case form, for methods taken from path
+	pathNames map[string]string
+	opt       *Options
 }
 
 // NewServiceHandler creates an http.Handler that serves all public method of serv.
@@ -237,37 +1722,242 @@ type serviceHandler struct {
 // Note that RegisterService exports all public method of serv, it would generally be safer to pass in an interface
 // instead of struct, to avoid unintentially exports methods that's not intended to serve externally.
 func NewServiceHandler(serv interface{}, opt *Options) http.Handler {
+	return NewMultiServiceHandler(opt, Service{Impl: serv})
+}
+
+// Service is a service to be served by NewMultiServiceHandler.
+type Service struct {
+	// Impl provides methods like serv of NewServiceHandler.
+	Impl interface{}
+	// Options for methods of this service, nil to use the options shared by all services.
+	Options *Options
+}
+
+// NewMultiServiceHandler is like NewServiceHandler, but serves methods of several services
+// behind one endpoint, dispatched by method name. It panics when services have methods of the
+// same name. Each service can have its own Options for its methods, opt is used for services
+// without one, and for the endpoint itself, e.g. NotFoundHandler.
+func NewMultiServiceHandler(opt *Options, servs ...Service) http.Handler {
+	opt = initOptions(opt)
+	h := &serviceHandler{methods: newMethodHandlers(opt, servs), opt: opt}
+	if opt.PathPrefix != "" {
+		h.pathNames = map[string]string{}
+		for mn := range h.methods {
+			h.pathNames[camelCaseToUnderscore(mn)] = mn
+		}
+	}
+	return h
+}
+
+// newMethodHandlers creates handlers of methods of servs, keyed by method name.
+func newMethodHandlers(opt *Options, servs []Service) map[string]http.Handler {
+	methods := map[string]http.Handler{}
+	for _, serv := range servs {
+		sopt := opt
+		if serv.Options != nil {
+			sopt = initOptions(serv.Options)
+		}
+		servVal := reflect.ValueOf(serv.Impl)
+		servType := reflect.TypeOf(serv.Impl)
+		for i := 0; i < servType.NumMethod(); i++ {
+			m := servType.Method(i)
+			mn := m.Name
+			if sopt.MethodMapper != nil {
+				var include bool
+				if mn, include = sopt.MethodMapper(m); !include {
+					continue
+				}
+			}
+			fn := servVal.MethodByName(m.Name)
+			if sopt.SkipInvalidMethods {
+				if err := checkMethodShape(mn, fn.Type(), sopt); err != nil {
+					sopt.logf("swiffy: skipped method %s, %v", m.Name, err)
+					continue
+				}
+			}
+			if _, ok := methods[mn]; ok {
+				panic(fmt.Sprintf("method %s is provided by more than one service", mn))
+			}
+			methods[mn] = newMethodHandler(mn, fn.Interface(), sopt)
+		}
+	}
+	if opt.MaxMethods > 0 && len(methods) > opt.MaxMethods {
+		panic(fmt.Sprintf("%d methods to be served exceed MaxMethods %d: %s", len(methods), opt.MaxMethods, strings.Join(sortedNames(methods), ", ")))
+	}
+	return methods
+}
+
+func sortedNames(methods map[string]http.Handler) []string {
+	names := make([]string, 0, len(methods))
+	for mn := range methods {
+		names = append(names, mn)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// initOptions fills defaults of opt and validates it.
+func initOptions(opt *Options) *Options {
 	if opt == nil {
 		opt = &Options{}
 	}
+	if opt.StrictQuery && opt.DecodeQuery {
+		panic("Options.StrictQuery and DecodeQuery are mutually exclusive")
+	}
+	codec := newProtoCodec(opt)
 	if opt.RequestDecoder == nil {
-		opt.RequestDecoder = ProtoDecoder
+		opt.RequestDecoder = codec.decode
+		opt.codec = codec
 	}
 	if opt.ResponseEncoder == nil {
-		opt.ResponseEncoder = ProtoEncoder
+		opt.ResponseEncoder = codec.encode
 	}
-
-	methods := map[string]http.Handler{}
-	servVal := reflect.ValueOf(serv)
-	servType := reflect.TypeOf(serv)
-	for i := 0; i < servType.NumMethod(); i++ {
-		mn := servType.Method(i).Name
-		methods[mn] = newMethodHandler(servVal.MethodByName(mn).Interface(), opt)
+	if opt.DepsFunc != nil {
+		checkDepsFunc(opt.DepsFunc)
 	}
-	return &serviceHandler{methods: methods}
+	if opt.RecentRequests > 0 && opt.recent == nil {
+		opt.recent = newRecentLog(opt.RecentRequests, opt.RecentRedactFields)
+	}
+	return opt
 }
 
+// preFilteredKey is the context key of Options whose PreFilter has passed the request.
+type preFilteredKey struct{}
+
 func (h *serviceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.opt.PreFilter != nil {
+		if !h.opt.PreFilter(w, r) {
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), preFilteredKey{}, h.opt))
+	}
+	body := r.Body
+	h.opt.limitBody(w, r)
+	if !h.opt.checkHTTPS(w, r) || !h.opt.checkQueryLen(w, r) || !h.opt.checkQueryParams(w, r) ||
+		!h.opt.parseForm(w, r) {
+		return
+	}
+	// The method handler caps body by itself, or not at all for ingest methods.
+	r.Body = body
 	method := r.FormValue("method")
+	if name := duplicateParam(r, "method", "format"); name != "" {
+		h.opt.httpError(w, r, 400, fmt.Sprintf("Duplicate %s parameter", name))
+		return
+	}
+	if method == "" && h.opt.JSONRPCEnvelope {
+		nm, nr, err := h.unwrapJSONRPC(w, r)
+		if err != nil {
+			h.opt.httpError(w, r, err.(WithHTTPStatus).HTTPStatus(), err.Error())
+			return
+		}
+		method, r = nm, nr
+	}
+	if method == "" && h.opt.PathPrefix != "" {
+		method = h.methodFromPath(r.URL.Path)
+	}
 	if method == "" {
-		http.Error(w, "No method parameter", 400)
+		h.opt.httpError(w, r, 400, "No method parameter")
+		return
+	}
+	if method == reflectionMethod && h.opt.Reflection {
+		h.serveReflection(w, r)
+		return
+	}
+	if method == versionMethod && h.opt.Version != nil {
+		h.serveVersion(w, r)
+		return
+	}
+	if method == recentMethod && h.opt.recent != nil {
+		h.serveRecent(w, r)
+		return
+	}
+	// Reserved methods not enabled are not found like others.
+	reserved := method == reflectionMethod || method == versionMethod || method == recentMethod
+	if h.opt.StrictMethodNames && !reserved && !isPlainMethodName(method) {
+		h.opt.httpError(w, r, 400, "Invalid method name")
 		return
 	}
 	var mh http.Handler
 	var ok bool
 	if mh, ok = h.methods[method]; !ok {
-		http.Error(w, "Method not found", 404)
+		if h.opt.NotFoundHandler != nil {
+			h.opt.NotFoundHandler.ServeHTTP(w, r)
+			return
+		}
+		h.opt.httpError(w, r, 404, "Method not found")
 		return
 	}
 	mh.ServeHTTP(w, r)
 }
+
+// isPlainMethodName tells whether name matches [A-Za-z][A-Za-z0-9]*.
+func isPlainMethodName(name string) bool {
+	for i, c := range name {
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return false
+	}
+	return name != ""
+}
+
+// methodFromPath returns method named by what follows PathPrefix in path, either Go name or snake
+// case as mounted by RegisterMethods, or empty if path isn't under PathPrefix.
+func (h *serviceHandler) methodFromPath(path string) string {
+	if !strings.HasPrefix(path, h.opt.PathPrefix) {
+		return ""
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(path, h.opt.PathPrefix), "/")
+	if mn, ok := h.pathNames[name]; ok {
+		return mn
+	}
+	return name
+}
+
+// unwrapJSONRPC reads body of r as {"method": "Bar", "params": {...}}, and returns method with a
+// request whose body is params.
+func (h *serviceHandler) unwrapJSONRPC(w http.ResponseWriter, r *http.Request) (string, *http.Request, error) {
+	if f := r.FormValue("format"); f != "" && f != "json" {
+		return "", nil, Error(400, "JSON-RPC envelope requires json format", nil)
+	}
+	rb, err := h.opt.readBody(w, r)
+	if err != nil {
+		return "", nil, err
+	}
+	var env struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(rb, &env); err != nil {
+		return "", nil, Error(400, fmt.Sprintf("Decode JSON-RPC envelope failed, %v", err), nil)
+	}
+	if env.Method == "" {
+		return "", nil, Error(400, "JSON-RPC envelope has no method", nil)
+	}
+	r = r.Clone(r.Context())
+	r.Header.Del("Content-Encoding")
+	r.Body = ioutil.NopCloser(bytes.NewReader(env.Params))
+	r.ContentLength = int64(len(env.Params))
+	return env.Method, r, nil
+}
+
+// duplicateParam returns the first of names given more than once in form of r, query and body
+// together, or empty if none. Otherwise FormValue silently picks one, masking client bugs or
+// letting a proxy and swiffy see different values. Form must be parsed already.
+func duplicateParam(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if len(r.Form[name]) > 1 {
+			return name
+		}
+	}
+	return ""
+}
+
+type methodNameKey struct{}
+
+// MethodName returns name of the method being served, it's available in context passed to
+// Middleware and backend. Useful for middlewares that keep per method state.
+func MethodName(ctx context.Context) string {
+	s, _ := ctx.Value(methodNameKey{}).(string)
+	return s
+}
@@ -0,0 +1,141 @@
+package swiffy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Timings provides a Middleware recording latency of calls per method, keyed by
+// MethodName(ctx), for quick performance investigations without a metrics backend.
+//
+// Latencies are counted in exponential buckets, from 100µs doubling up to about 14 minutes,
+// so percentiles reported by Stats are upper bounds of buckets, accurate within a factor of 2.
+// Recording takes a few atomic adds, safe for concurrent use.
+type Timings struct {
+	mu      sync.RWMutex
+	methods map[string]*timingHistogram
+}
+
+const (
+	timingBase    = 100 * time.Microsecond
+	timingBuckets = 24
+)
+
+type timingHistogram struct {
+	count   int64
+	errors  int64
+	sum     int64 // nanoseconds
+	max     int64
+	buckets [timingBuckets]int64
+}
+
+// TimingStats summarizes calls of a method recorded by Timings.
+type TimingStats struct {
+	Count  int64
+	Errors int64
+	Mean   time.Duration
+	P50    time.Duration
+	P90    time.Duration
+	P99    time.Duration
+	Max    time.Duration
+}
+
+// NewTimings creates an empty Timings.
+func NewTimings() *Timings {
+	return &Timings{methods: map[string]*timingHistogram{}}
+}
+
+// Middleware implements Middleware.
+func (t *Timings) Middleware(h Handler) Handler {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		hist := t.histogram(MethodName(ctx))
+		start := time.Now()
+		res, err := h(ctx, req)
+		hist.record(time.Since(start), err != nil)
+		return res, err
+	}
+}
+
+func (t *Timings) histogram(method string) *timingHistogram {
+	t.mu.RLock()
+	hist, ok := t.methods[method]
+	t.mu.RUnlock()
+	if ok {
+		return hist
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if hist, ok = t.methods[method]; !ok {
+		hist = &timingHistogram{}
+		t.methods[method] = hist
+	}
+	return hist
+}
+
+func (hist *timingHistogram) record(d time.Duration, failed bool) {
+	atomic.AddInt64(&hist.count, 1)
+	if failed {
+		atomic.AddInt64(&hist.errors, 1)
+	}
+	atomic.AddInt64(&hist.sum, int64(d))
+	for {
+		max := atomic.LoadInt64(&hist.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&hist.max, max, int64(d)) {
+			break
+		}
+	}
+	i := 0
+	for bound := timingBase; d > bound && i < timingBuckets-1; bound *= 2 {
+		i++
+	}
+	atomic.AddInt64(&hist.buckets[i], 1)
+}
+
+// Stats returns stats of methods called so far, keyed by method name.
+func (t *Timings) Stats() map[string]TimingStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	stats := make(map[string]TimingStats, len(t.methods))
+	for method, hist := range t.methods {
+		stats[method] = hist.stats()
+	}
+	return stats
+}
+
+func (hist *timingHistogram) stats() TimingStats {
+	var buckets [timingBuckets]int64
+	var total int64
+	for i := range buckets {
+		buckets[i] = atomic.LoadInt64(&hist.buckets[i])
+		total += buckets[i]
+	}
+	s := TimingStats{
+		Count:  atomic.LoadInt64(&hist.count),
+		Errors: atomic.LoadInt64(&hist.errors),
+		Max:    time.Duration(atomic.LoadInt64(&hist.max)),
+	}
+	if s.Count > 0 {
+		s.Mean = time.Duration(atomic.LoadInt64(&hist.sum) / s.Count)
+	}
+	percentile := func(p float64) time.Duration {
+		// Rank of the call at percentile p, 1 based.
+		rank := int64(p*float64(total) + 0.999999)
+		var seen int64
+		bound := timingBase
+		for i := range buckets {
+			seen += buckets[i]
+			if seen >= rank && seen > 0 {
+				break
+			}
+			bound *= 2
+		}
+		if bound > s.Max {
+			return s.Max
+		}
+		return bound
+	}
+	s.P50, s.P90, s.P99 = percentile(0.5), percentile(0.9), percentile(0.99)
+	return s
+}
@@ -0,0 +1,63 @@
+package swiffy
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// TransferHandler wraps h to report bytes transferred by each request to onTransfer, once h
+// returns, attributed to the client told by clientID, e.g. an API token. A nil clientID takes
+// client IP from RemoteAddr. It's the plumbing for bandwidth quotas: onTransfer accounts usage,
+// and a wrapper or Options.PreFilter rejects clients over quota.
+//
+// in counts request body bytes read by h, as received, i.e. before decompression; query and
+// headers are not counted. out counts response body bytes written, after compression by
+// Options.Compression when TransferHandler wraps swiffy's handler.
+func TransferHandler(h http.Handler, clientID func(r *http.Request) string, onTransfer func(clientID string, in, out int64)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var id string
+		if clientID != nil {
+			id = clientID(r)
+		} else if ip := clientIP(r, false); ip != nil {
+			id = ip.String()
+		}
+		cw := &countingWriter{ResponseWriter: w}
+		cr := &countingReader{ReadCloser: http.NoBody}
+		if r.Body != nil {
+			cr.ReadCloser = r.Body
+		}
+		r.Body = cr
+		defer func() { onTransfer(id, atomic.LoadInt64(&cr.n), atomic.LoadInt64(&cw.n)) }()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// countingReader counts bytes read from the underlying ReadCloser.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
+
+// countingWriter counts bytes written to the underlying ResponseWriter.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	atomic.AddInt64(&w.n, int64(n))
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
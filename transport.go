@@ -0,0 +1,86 @@
+package swiffy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// HandlerTransport is an http.RoundTripper that serves requests by calling Handler in-process,
+// no network involved. It's meant for tests, plug it into a standard http.Client so the client
+// code is exercised unchanged:
+//
+//	client := &http.Client{Transport: &swiffy.HandlerTransport{Handler: swiffy.NewServiceHandler(serv, nil)}}
+//	resp, err := client.Post("http://test/api?method=Hello", "application/json", body)
+type HandlerTransport struct {
+	Handler http.Handler
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *HandlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Server side handlers expect a server request: RequestURI set and a non-nil Body.
+	r := req.Clone(req.Context())
+	r.RequestURI = req.URL.RequestURI()
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	if r.Host == "" {
+		r.Host = req.URL.Host
+	}
+	tw := &transportWriter{header: http.Header{}}
+	t.Handler.ServeHTTP(tw, r)
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return tw.response(req), nil
+}
+
+// transportWriter is the http.ResponseWriter HandlerTransport serves requests to, buffering
+// the response in memory.
+type transportWriter struct {
+	header http.Header
+	// Header as of WriteHeader, later changes don't go to client
+	sent   http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *transportWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *transportWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	w.sent = w.header.Clone()
+}
+
+func (w *transportWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(200)
+	return w.body.Write(b)
+}
+
+// Flush does nothing, the whole response is returned once handler is done.
+func (w *transportWriter) Flush() {}
+
+func (w *transportWriter) response(req *http.Request) *http.Response {
+	w.WriteHeader(200)
+	if w.sent.Get("Content-Type") == "" && w.body.Len() > 0 {
+		// As net/http server does.
+		w.sent.Set("Content-Type", http.DetectContentType(w.body.Bytes()))
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%03d %s", w.status, http.StatusText(w.status)),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.sent,
+		Body:          io.NopCloser(bytes.NewReader(w.body.Bytes())),
+		ContentLength: int64(w.body.Len()),
+		Request:       req,
+	}
+}
@@ -0,0 +1,111 @@
+package swiffy
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// TTLCache is a concurrency safe cache of values that expire some time after they are set,
+// for middlewares to remember results of expensive checks, e.g. token validation keyed by a
+// hash of the token. When full, setting a new key evicts the least recently used entry.
+type TTLCache struct {
+	ttl time.Duration
+	max int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// Most recently used at front
+	lru *list.List
+	// When Set next removes all expired entries
+	sweepAt time.Time
+}
+
+type ttlEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewTTLCache creates a TTLCache whose entries expire after ttl, holding up to maxEntries of
+// them, unbounded if maxEntries is not positive. Expired entries are removed by Set once per ttl
+// at most, so an unbounded cache holds about entries set in the last two ttl.
+func NewTTLCache(ttl time.Duration, maxEntries int) *TTLCache {
+	return &TTLCache{ttl: ttl, max: maxEntries, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+// Get returns value of key, and whether it's there and not expired.
+func (c *TTLCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*ttlEntry)
+	if !time.Now().Before(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e.value, true
+}
+
+// Set sets value of key, to expire after ttl of the cache.
+func (c *TTLCache) Set(key string, value interface{}) {
+	c.SetTTL(key, value, c.ttl)
+}
+
+// SetTTL is Set with ttl of its own.
+func (c *TTLCache) SetTTL(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if !now.Before(c.sweepAt) {
+		c.sweep(now)
+		c.sweepAt = now.Add(c.ttl)
+	}
+	expires := now.Add(ttl)
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*ttlEntry)
+		e.value, e.expires = value, expires
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&ttlEntry{key: key, value: value, expires: expires})
+	for c.max > 0 && c.lru.Len() > c.max {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Delete removes key.
+func (c *TTLCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+// Len returns number of entries, including expired ones not yet removed.
+func (c *TTLCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// sweep removes entries expired at now.
+func (c *TTLCache) sweep(now time.Time) {
+	for el := c.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if !now.Before(el.Value.(*ttlEntry).expires) {
+			c.remove(el)
+		}
+		el = prev
+	}
+}
+
+func (c *TTLCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*ttlEntry).key)
+}
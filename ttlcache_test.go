@@ -0,0 +1,83 @@
+package swiffy
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTTLCacheExpiry(t *testing.T) {
+	c := NewTTLCache(20*time.Millisecond, 0)
+	c.Set("a", 1)
+	c.SetTTL("b", 2, time.Hour)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %v, %v before expiry", v, ok)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if v, ok := c.Get("a"); ok {
+		t.Errorf("Get(a) = %v after expiry", v)
+	}
+	if v, ok := c.Get("b"); !ok || v != 2 {
+		t.Errorf("Get(b) = %v, %v, want 2 by its own ttl", v, ok)
+	}
+	c.Delete("b")
+	if _, ok := c.Get("b"); ok {
+		t.Error("b found after Delete")
+	}
+}
+
+func TestTTLCacheEviction(t *testing.T) {
+	c := NewTTLCache(time.Hour, 2)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Error("least recently used b not evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := c.Get(k); !ok {
+			t.Errorf("%s evicted", k)
+		}
+	}
+	if n := c.Len(); n != 2 {
+		t.Errorf("Len() = %d, want 2", n)
+	}
+}
+
+func TestTTLCacheSweep(t *testing.T) {
+	c := NewTTLCache(10*time.Millisecond, 0)
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint(i), i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	// Expired keys are never read again, Set removes them.
+	c.Set("new", 0)
+	if n := c.Len(); n != 1 {
+		t.Errorf("Len() = %d after expiry, want 1", n)
+	}
+}
+
+func TestTTLCacheConcurrent(t *testing.T) {
+	c := NewTTLCache(time.Millisecond, 50)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k := fmt.Sprint(g, i%100)
+				c.Set(k, i)
+				c.Get(k)
+				if i%10 == 0 {
+					c.Delete(k)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := c.Len(); n > 50 {
+		t.Errorf("Len() = %d over max 50", n)
+	}
+}
@@ -0,0 +1,25 @@
+package swiffy
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// versionMethod is the method name build info is served as, see Options.Version.
+const versionMethod = "__version__"
+
+// VersionInfo describes the running build, served by method __version__ when set as
+// Options.Version, to confirm which build is live behind a load balancer. Values are usually
+// set at build time by -ldflags "-X ...".
+type VersionInfo struct {
+	Version   string `json:"version,omitempty"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"buildTime,omitempty"`
+}
+
+// serveVersion responds Options.Version as JSON.
+func (h *serviceHandler) serveVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType(h.opt.ContentTypes, "json"))
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(h.opt.Version)
+}
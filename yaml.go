@@ -0,0 +1,19 @@
+package swiffy
+
+import (
+	"sigs.k8s.io/yaml"
+)
+
+// Format yaml is json in YAML syntax: requests are converted to JSON then decoded by jsonpb,
+// responses are encoded as json then converted to YAML. Conversion is kept here so that the
+// YAML dependency stays in one place.
+
+// yamlToJSON converts YAML document src to JSON.
+func yamlToJSON(src []byte) ([]byte, error) {
+	return yaml.YAMLToJSON(src)
+}
+
+// jsonToYAML converts JSON src to a YAML document.
+func jsonToYAML(src []byte) ([]byte, error) {
+	return yaml.JSONToYAML(src)
+}